@@ -1,26 +1,41 @@
 package cli
 
 import (
+	"archive/tar"
+	"bufio"
 	"bytes"
 	"context"
 	"crypto/tls"
 	_ "embed"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
+	"os/user"
+	"strconv"
 	"strings"
+	"syscall"
 	"text/tabwriter"
 	"text/template"
 	"time"
 
 	"connectrpc.com/connect"
+	"github.com/compose-spec/compose-go/v2/types"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/go-units"
 	"github.com/fatih/color"
+	"github.com/moby/term"
+	"github.com/pgulb/plasma/container"
+	"github.com/pgulb/plasma/controller"
 	"github.com/pgulb/plasma/db"
+	deployv1 "github.com/pgulb/plasma/gen/deploy/v1"
+	"github.com/pgulb/plasma/gen/deploy/v1/deployv1connect"
 	logsv1 "github.com/pgulb/plasma/gen/logs/v1"
 	"github.com/pgulb/plasma/gen/logs/v1/logsv1connect"
 	"github.com/pgulb/plasma/server"
@@ -28,29 +43,170 @@ import (
 )
 
 const usage = `Usage:
-  plasma create -n <project-name> -c [optional] <compose-file>
+  plasma create -n <project-name> -c [optional] <compose-file> [--strict] [--wait] [--timeout 5m]
 	<compose-file> - default: docker-compose.yml
   - creates a new project from a docker compose file
   - fails if project with this name already exists
+  - warns about compose features plasma ignores, e.g. networks, secrets or deploy.resources,
+    and fails on services it can't run, e.g. build without image
+  - with --strict fails on warnings too
+  - images are pulled when containers are started, following service's pull_policy:
+    missing (default), always, never, daily, weekly or every_<duration>
+  - every image is resolved to its digest in the registry and containers are created from it,
+    so a moved tag does not change what runs until 'plasma update-images',
+    except services with pull_policy always or daily, weekly, every_<duration>
+  - images are pulled and containers created for service's platform, e.g. platform: linux/arm64,
+    or server's platform without it, create fails if image is not available for it
+  - build contexts of services with build section are uploaded, respecting .dockerignore,
+    and their images are built by plasma-server, each build tagged <project>-<service>:build-<id>
+  - with --wait services are deployed right away instead of by the controller, showing
+    image pull progress, container creation and health, and create fails if any service
+    does not become healthy within --timeout
+
+  plasma apply -n <project-name> -c [optional] <compose-file> [--strict]
+	<compose-file> - default: docker-compose.yml
+  - updates existing project from a docker compose file
+  - compose file is validated like in create, --strict works the same
+  - images of services with build section are built again from uploaded contexts
+  - images are resolved to digests again, services whose tag moved are updated
+  - services with changed config are replaced by the controller using their update strategy,
+    set by deploy.update_config.order (start-first means rolling) or x-plasma-update:
+      x-plasma-update:
+        strategy: rolling   # recreate (default), rolling or blue-green
+        health_timeout: 2m  # how long new containers have to become healthy
+        monitor: 5s         # how long they have to stay healthy
+  - rolling and blue-green updates are rolled back if new containers don't become healthy
+  - services can update themselves when their image's tag moves to a new digest in the registry:
+      x-plasma-auto-update:
+        interval: 1h                       # how often the registry is checked
+        windows: ["Mon-Fri 02:00-04:00"]   # optional maintenance windows, server's time zone
+    or just x-plasma-auto-update: true, new digest is deployed using the update strategy
+    and service goes back to old one if new containers don't become healthy, see plasma events
+  - services removed from compose file are removed, their volumes are kept
+
+  plasma plan -n <project-name> -c [optional] <compose-file>
+	<compose-file> - default: docker-compose.yml
+  - shows which services, volumes and networks create or apply would create,
+    recreate or remove, which fields change and which compose features plasma ignores
+  - does not change anything
+
+  plasma history -n <project-name>
+  - lists revisions of the project, made on every create, apply and rollback
+
+  plasma diff -n <project-name> --rev <a> --rev <b>
+  - shows what changed in the project between revisions a and b
+
+  plasma rollback -n <project-name> --to <revision>
+  - applies compose file of given revision again, as a new revision
+  - images are pinned to digests of that revision
+
+  plasma update-images -n <project-name> [service...]
+  - pins given services or all services of the project to current digests of their images,
+    as a new revision, services sharing an image share its digest
+  - controller updates services whose digest changed using their update strategy
 
   plasma ps
   - lists all plasma-managed resources
 
+  plasma ps -n <project-name> <service>
+  - shows service details, digest its image is pinned to and what the controller last did with it
+
+  plasma stop -n <project-name> [service...]
+  - stops the project or only given services without deleting them
+
+  plasma start -n <project-name> [service...]
+  - starts stopped project (with all of its services) or given services
+
+  plasma scale -n <project-name> <service>=<replicas>...
+  - sets how many containers of the service should run, e.g. web=3
+  - replicas are named <project>_<service>_<i> and share the service's network alias,
+    except the first one, which keeps name <project>_<service>, so scaling a service
+    up or down never replaces its first container
+
+  plasma events [-n <project-name>] [--limit 50]
+  - lists decisions plasma made on its own, e.g. autoscaling, newest first
+  - autoscaling is configured with x-plasma-autoscale service extension:
+      x-plasma-autoscale:
+        min: 1
+        max: 5
+        cpu: 70      # target CPU % per replica, 100 is one core
+        memory: 80   # target memory % of limit per replica
+        cooldown_up: 1m
+        cooldown_down: 5m
+
+  plasma restart -n <project-name> [--pull] [--timeout 60s] [service...]
+  - gracefully stops and starts given services or all services of the project
+  - with --pull pulls images again before starting, images pinned to digest are
+    only pulled if missing, use update-images to move them
+  - waits until services are healthy or --timeout expires
+
+  plasma pause -n <project-name> [service...]
+  - makes the controller leave the project or only given services alone
+    e.g. to debug a container by hand
+
+  plasma unpause -n <project-name> [service...]
+  - lets the controller manage the project or given services again
+
+  plasma prune [--containers] [--volumes] [--networks] [--images] [--dry-run]
+  - removes plasma-labeled containers, volumes and networks no longer known
+    to plasma-server and dangling images plasma pulled
+  - without flags prunes containers, networks and images, volumes only with --volumes
+  - with --dry-run only lists what would be removed
+
+  plasma images
+  - lists images plasma pulled, pushed or built, their size and whether they are still needed:
+    used by a container, current image of a service or one of its last digests kept for rollback
+  - the controller removes unused images plasma pulled, never pushed or built ones,
+    configured on plasma-server with:
+      PLASMA_IMAGE_GC_KEEP=3           # last digests of every service kept for rollback
+      PLASMA_IMAGE_GC_MAX_AGE=168h     # remove unused images pulled longer ago, 0 disables
+      PLASMA_IMAGE_GC_MAX_SIZE=10GB    # remove unused images, oldest first, while plasma's
+                                       # images take more, not set by default
+      PLASMA_IMAGE_GC_INTERVAL=1h      # how often images are checked
+
+  plasma image push <image>...
+  - uploads images from local docker to plasma-server, without a registry
+  - pushed images are never pulled, push them again and restart services to update them
+
+  plasma registry login [-n <project-name>] -u <username> [--password-stdin] <host>
+  - stores credentials for pulling private images from registry <host>, e.g. ghcr.io
+  - with -n used only by given project, else by all projects without their own login
+  - credentials are checked against the registry and stored encrypted
+    with key from PLASMA_SECRET_KEY, which must be set on plasma-server
+
+  plasma registry logout [-n <project-name>] <host>
+  - removes stored credentials
+
+  plasma registry ls
+  - lists stored registry logins
+
   plasma serve
   - deploys plasma-server to local docker
 
   plasma destroy
   - destroys plasma-server ran using 'plasma serve'
 
-  plasma logs <container-name>
+  plasma logs [-f] [--tail 100] [--since 10m] [--until 1m] [-t] [--stdout] [--stderr] <container-name>
   - streams logs from plasma-server for <container-name> through gRPC
+  - -f keeps following new lines until interrupted, --tail shows only last lines
+  - --since and --until take a timestamp or duration relative to now, e.g. 10m
+  - -t shows timestamps, --stdout or --stderr show only one of the streams,
+    stderr is printed in red to stderr
 
-  plasma logs
-  - streams logs for plasma-server itself
+  plasma logs [flags]
+  - streams logs for plasma-server itself, flags work the same
+
+  plasma logs -n <project-name> [flags] [service...]
+  - streams logs of all replicas of given services or all services of the project,
+    merged in time order and prefixed with container's name, flags work the same
+  - with -f containers created later, e.g. by updates, are followed too
 `
 
 const wrongOrMissingParameters = "\nWrong or missing command parameters, check usage"
 
+// timeout of requests which make plasma-server resolve image digests in registries
+const resolveTimeout = 2 * time.Minute
+
 var baseURL string
 var client *http.Client
 
@@ -61,8 +217,11 @@ var plasmaCompose string
 var plasmaComposeDev string
 
 type QueryParams struct {
-	Compose *string `json:"compose"`
-	Project *string `json:"project"`
+	Compose  *string           `json:"compose"`
+	Project  *string           `json:"project"`
+	Services []string          `json:"services"`
+	Extra    map[string]string `json:"extra"` // any other query params
+	Body     []byte            `json:"body"`  // request body, if any
 }
 
 type verTpl struct {
@@ -93,7 +252,11 @@ func initBaseURL() {
 }
 
 func reqDo(method string, url string, qp *QueryParams) (*server.RespMsg, int, error) {
-	req, err := http.NewRequest(method, baseURL+url, nil)
+	var body io.Reader
+	if qp.Body != nil {
+		body = bytes.NewReader(qp.Body)
+	}
+	req, err := http.NewRequest(method, baseURL+url, body)
 	if err != nil {
 		return &server.RespMsg{}, 0, err
 	}
@@ -104,6 +267,12 @@ func reqDo(method string, url string, qp *QueryParams) (*server.RespMsg, int, er
 	if qp.Project != nil {
 		q.Add("project", *qp.Project)
 	}
+	for _, svc := range qp.Services {
+		q.Add("service", svc)
+	}
+	for k, v := range qp.Extra {
+		q.Add(k, v)
+	}
 	req.URL.RawQuery = q.Encode()
 	resp, err := client.Do(req)
 	if err != nil {
@@ -164,6 +333,646 @@ func checkServerVer() {
 	}
 }
 
+// projectAction sends POST to /projects/<name><path> for project
+// and services given in command line args.
+func projectAction(cmd string, path string) {
+	actionCmd := flag.NewFlagSet(cmd, flag.ExitOnError)
+	projName := actionCmd.String("n", "", "project name")
+	actionCmd.Parse(os.Args[2:])
+	if *projName == "" {
+		color.Magenta(usage)
+		color.Red(wrongOrMissingParameters)
+		os.Exit(1)
+	}
+	msg, status, err := reqDo(
+		"POST",
+		"/projects/"+*projName+path,
+		&QueryParams{Services: actionCmd.Args()},
+	)
+	if err != nil {
+		color.Magenta(msg.Msg)
+		color.Red(err.Error())
+		os.Exit(1)
+	}
+	color.Magenta(fmt.Sprintf("HTTP status code %v", status))
+	if status != 200 {
+		color.Red(msg.Msg)
+		os.Exit(1)
+	}
+	color.Magenta(msg.Msg)
+}
+
+// projectServices returns names of services in project,
+// without '<project-name>_' prefix.
+func projectServices(projName string) []string {
+	msg, status, err := reqDo("GET", "/ps", &QueryParams{})
+	if err != nil {
+		color.Red(err.Error())
+		os.Exit(1)
+	}
+	if status != 200 {
+		color.Red(msg.Msg)
+		os.Exit(1)
+	}
+	var psResp server.PsResp
+	err = json.Unmarshal([]byte(msg.Msg), &psResp)
+	if err != nil {
+		color.Red(err.Error())
+		os.Exit(1)
+	}
+	var projID uint
+	for _, p := range psResp.Projects {
+		if p.Name == projName {
+			projID = p.ID
+		}
+	}
+	if projID == 0 {
+		color.Red(fmt.Sprintf("Project '%s' not found", projName))
+		os.Exit(1)
+	}
+	svcNames := []string{}
+	for _, svc := range psResp.Services {
+		if svc.ProjectId == projID {
+			svcNames = append(svcNames, strings.TrimPrefix(svc.Name, projName+"_"))
+		}
+	}
+	return svcNames
+}
+
+// author identifies who creates a project revision,
+// PLASMA_AUTHOR or <user>@<hostname> by default.
+func author() string {
+	if a := os.Getenv("PLASMA_AUTHOR"); a != "" {
+		return a
+	}
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return name
+	}
+	return name + "@" + host
+}
+
+type revsFlag []string
+
+func (r *revsFlag) String() string {
+	return strings.Join(*r, ",")
+}
+
+func (r *revsFlag) Set(v string) error {
+	*r = append(*r, v)
+	return nil
+}
+
+func printChanges(changes []db.Change) {
+	if len(changes) == 0 {
+		color.Magenta("No changes.")
+		return
+	}
+	for _, c := range changes {
+		switch c.Action {
+		case "create":
+			color.Green(fmt.Sprintf("+ %s %s", c.Kind, c.Name))
+		case "remove":
+			color.Red(fmt.Sprintf("- %s %s", c.Kind, c.Name))
+		default:
+			color.Yellow(fmt.Sprintf("~ %s %s (%s)", c.Kind, c.Name, c.Action))
+		}
+		for _, f := range c.Fields {
+			fmt.Printf("    %s: %s -> %s\n", f.Field, f.Old, f.New)
+		}
+	}
+}
+
+// uploadBuilds sends build contexts of services with build section
+// to plasma-server, which builds their images, and shows build output.
+func uploadBuilds(projName string, composeFile string, composeB64 string) {
+	project, err := container.LoadComposeFile(projName, composeFile)
+	if err != nil {
+		color.Red(err.Error())
+		os.Exit(1)
+	}
+	names := []string{}
+	for _, name := range project.ServiceNames() {
+		if project.Services[name].Build != nil {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+	color.Magenta(fmt.Sprintf("Uploading build contexts of %s...\n", strings.Join(names, ", ")))
+	bundle, bundleW := io.Pipe()
+	go func() {
+		bundleW.CloseWithError(writeBundle(bundleW, project, names))
+	}()
+	req, err := http.NewRequest("POST", baseURL+"/projects/"+projName+"/build", bundle)
+	if err != nil {
+		color.Red(err.Error())
+		os.Exit(1)
+	}
+	q := req.URL.Query()
+	q.Add("compose", composeB64)
+	req.URL.RawQuery = q.Encode()
+	req.Header.Set("Content-Type", "application/x-tar")
+	// builds take longer than other requests
+	buildClient := *client
+	buildClient.Timeout = 0
+	resp, err := buildClient.Do(req)
+	if err != nil {
+		color.Red(err.Error())
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		color.Magenta(fmt.Sprintf("HTTP status code %v", resp.StatusCode))
+		var msg server.RespMsg
+		err := json.NewDecoder(resp.Body).Decode(&msg)
+		if err != nil {
+			color.Red(err.Error())
+		}
+		color.Red(msg.Msg)
+		os.Exit(1)
+	}
+	fd, isTerm := term.GetFdInfo(os.Stdout)
+	err = jsonmessage.DisplayJSONMessagesStream(resp.Body, os.Stdout, fd, isTerm, nil)
+	if err != nil {
+		color.Red(err.Error())
+		os.Exit(1)
+	}
+	fmt.Println()
+}
+
+// deployWait deploys project's services through gRPC and shows progress
+// until they are healthy, exiting with error if any of them fails
+// or timeout expires first.
+func deployWait(projName string, timeout time.Duration) {
+	color.Magenta(fmt.Sprintf("\nDeploying project %s...\n", projName))
+	// stream lasts until timeout, deadline is passed to plasma-server
+	streamClient := *client
+	streamClient.Timeout = 0
+	grpcClient := deployv1connect.NewDeployServiceClient(
+		&streamClient,
+		"http://localhost:8081", // TODO: read from env or some config file
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	stream, err := grpcClient.Deploy(ctx, connect.NewRequest(&deployv1.DeployRequest{
+		Project: projName,
+	}))
+	if err != nil {
+		color.Red(err.Error())
+		os.Exit(1)
+	}
+	// events are rendered as json messages, same as pull progress in docker
+	msgs, msgsW := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		fd, isTerm := term.GetFdInfo(os.Stdout)
+		done <- jsonmessage.DisplayJSONMessagesStream(msgs, os.Stdout, fd, isTerm, nil)
+	}()
+	enc := json.NewEncoder(msgsW)
+	failed := []string{}
+	for stream.Receive() {
+		event := stream.Msg()
+		msg := jsonmessage.JSONMessage{ID: event.Container, Status: event.Message}
+		if msg.ID == "" {
+			msg.ID = event.Service
+		}
+		switch event.Kind {
+		case deployv1.EventKind_EVENT_KIND_PULL:
+			if event.Layer != "" {
+				msg.ID = event.Service + " " + event.Layer
+			}
+			if event.Total > 0 {
+				msg.Progress = &jsonmessage.JSONProgress{Current: event.Current, Total: event.Total}
+			}
+		case deployv1.EventKind_EVENT_KIND_HEALTH:
+			msg.Status = "health: " + event.Health
+		case deployv1.EventKind_EVENT_KIND_FAILED:
+			msg.Status = "failed: " + event.Message
+			failed = append(failed, msg.ID)
+		}
+		if err := enc.Encode(msg); err != nil {
+			break
+		}
+	}
+	msgsW.Close()
+	if err := <-done; err != nil {
+		color.Red(err.Error())
+	}
+	if err := stream.Err(); err != nil {
+		color.Red(err.Error())
+		os.Exit(1)
+	}
+	if len(failed) > 0 {
+		color.Red(fmt.Sprintf("Failed to deploy %s", strings.Join(failed, ", ")))
+		os.Exit(1)
+	}
+	color.Magenta(fmt.Sprintf("Project %s deployed and healthy", projName))
+}
+
+// writeBundle writes tar with build context tar of every given service.
+func writeBundle(w io.Writer, project *types.Project, names []string) error {
+	tw := tar.NewWriter(w)
+	for _, name := range names {
+		err := writeBuildContext(tw, project.Services[name])
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// writeBuildContext adds service's build context to bundle as <service>.tar,
+// buffering it in temp file, as tar header needs its size.
+func writeBuildContext(tw *tar.Writer, svc types.ServiceConfig) error {
+	buildCtx, err := container.BuildContext(&svc)
+	if err != nil {
+		return err
+	}
+	defer buildCtx.Close()
+	tmp, err := os.CreateTemp("", "plasma-build-*.tar")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	size, err := io.Copy(tmp, buildCtx)
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	err = tw.WriteHeader(&tar.Header{Name: svc.Name + ".tar", Mode: 0o600, Size: size})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, tmp)
+	return err
+}
+
+// pushImages streams 'docker save' output of images to plasma-server,
+// which loads them, and prints what it loaded.
+func pushImages(images []string) {
+	save := exec.Command("docker", append([]string{"save"}, images...)...)
+	save.Stderr = os.Stderr
+	out, err := save.StdoutPipe()
+	if err != nil {
+		color.Red(err.Error())
+		os.Exit(1)
+	}
+	err = save.Start()
+	if err != nil {
+		color.Red(err.Error())
+		os.Exit(1)
+	}
+	req, err := http.NewRequest("POST", baseURL+"/images", out)
+	if err != nil {
+		color.Red(err.Error())
+		os.Exit(1)
+	}
+	req.Header.Set("Content-Type", "application/x-tar")
+	// images can take long to upload
+	pushClient := *client
+	pushClient.Timeout = 0
+	resp, err := pushClient.Do(req)
+	if err != nil {
+		color.Red(err.Error())
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+	var msg server.RespMsg
+	err = json.NewDecoder(resp.Body).Decode(&msg)
+	if err != nil {
+		color.Red(err.Error())
+		os.Exit(1)
+	}
+	err = save.Wait()
+	if err != nil {
+		color.Red("docker save failed: " + err.Error())
+		os.Exit(1)
+	}
+	color.Magenta(fmt.Sprintf("HTTP status code %v", resp.StatusCode))
+	if resp.StatusCode != 200 {
+		color.Red(msg.Msg)
+		os.Exit(1)
+	}
+	var loaded []container.LoadedImage
+	err = json.Unmarshal([]byte(msg.Msg), &loaded)
+	if err != nil {
+		color.Red(err.Error())
+		os.Exit(1)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 1, 1, 1, ' ', 0)
+	fmt.Fprintln(w, "image\t|\tid\t|\tdigests\t")
+	fmt.Fprintln(w, "---\t|\t---\t|\t---\t")
+	for _, img := range loaded {
+		fmt.Fprintf(w, "%s\t|\t%s\t|\t%s\t\n", img.Ref, img.ID, strings.Join(img.Digests, ", "))
+	}
+	err = w.Flush()
+	if err != nil {
+		color.Red(err.Error())
+		os.Exit(1)
+	}
+}
+
+// readPassword reads password from stdin, or prompts for it without echo.
+func readPassword(fromStdin bool) (string, error) {
+	if fromStdin {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+	fd, isTerm := term.GetFdInfo(os.Stdin)
+	if !isTerm {
+		return "", errors.New("stdin is not a terminal, use --password-stdin")
+	}
+	state, err := term.SaveState(fd)
+	if err != nil {
+		return "", err
+	}
+	fmt.Print("Password: ")
+	err = term.DisableEcho(fd, state)
+	if err != nil {
+		return "", err
+	}
+	defer fmt.Println()
+	defer term.RestoreTerminal(fd, state)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func registryCmd() {
+	if len(os.Args) < 3 {
+		color.Magenta(usage)
+		color.Red(wrongOrMissingParameters)
+		os.Exit(1)
+	}
+	switch os.Args[2] {
+	case "login":
+		loginCmd := flag.NewFlagSet("registry login", flag.ExitOnError)
+		projName := loginCmd.String("n", "", "project to use the login for, default: all projects")
+		username := loginCmd.String("u", "", "username")
+		passwordStdin := loginCmd.Bool("password-stdin", false, "read password from stdin")
+		loginCmd.Parse(os.Args[3:])
+		if *username == "" || loginCmd.NArg() != 1 {
+			color.Magenta(usage)
+			color.Red(wrongOrMissingParameters)
+			os.Exit(1)
+		}
+		password, err := readPassword(*passwordStdin)
+		if err != nil {
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		body, err := json.Marshal(server.LoginReq{Username: *username, Password: password})
+		if err != nil {
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		host := container.RegistryHost(loginCmd.Arg(0))
+		msg, status, err := reqDo(
+			"POST",
+			"/registries/"+host+"/login",
+			&QueryParams{Body: body, Extra: map[string]string{"project": *projName}},
+		)
+		if err != nil {
+			color.Magenta(msg.Msg)
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		color.Magenta(fmt.Sprintf("HTTP status code %v", status))
+		if status != 200 {
+			color.Red(msg.Msg)
+			os.Exit(1)
+		}
+		color.Magenta(msg.Msg)
+	case "logout":
+		logoutCmd := flag.NewFlagSet("registry logout", flag.ExitOnError)
+		projName := logoutCmd.String("n", "", "project the login is for, default: all projects")
+		logoutCmd.Parse(os.Args[3:])
+		if logoutCmd.NArg() != 1 {
+			color.Magenta(usage)
+			color.Red(wrongOrMissingParameters)
+			os.Exit(1)
+		}
+		host := container.RegistryHost(logoutCmd.Arg(0))
+		msg, status, err := reqDo(
+			"POST",
+			"/registries/"+host+"/logout",
+			&QueryParams{Extra: map[string]string{"project": *projName}},
+		)
+		if err != nil {
+			color.Magenta(msg.Msg)
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		color.Magenta(fmt.Sprintf("HTTP status code %v", status))
+		if status != 200 {
+			color.Red(msg.Msg)
+			os.Exit(1)
+		}
+		color.Magenta(msg.Msg)
+	case "ls":
+		msg, status, err := reqDo("GET", "/registries", &QueryParams{})
+		if err != nil {
+			color.Magenta(msg.Msg)
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		color.Magenta(fmt.Sprintf("HTTP status code %v", status))
+		if status != 200 {
+			color.Red(msg.Msg)
+			os.Exit(1)
+		}
+		var regs []server.RegistryResp
+		err = json.Unmarshal([]byte(msg.Msg), &regs)
+		if err != nil {
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 1, 1, 1, ' ', 0)
+		fmt.Fprintln(w, "registry\t|\tproject\t|\tusername\t")
+		fmt.Fprintln(w, "---\t|\t---\t|\t---\t")
+		for _, reg := range regs {
+			project := reg.Project
+			if project == "" {
+				project = "(all)"
+			}
+			fmt.Fprintf(w, "%s\t|\t%s\t|\t%s\t\n", reg.Host, project, reg.Username)
+		}
+		err = w.Flush()
+		if err != nil {
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+	default:
+		color.Magenta(usage)
+		color.Red(wrongOrMissingParameters)
+		os.Exit(1)
+	}
+}
+
+// printIssues prints warnings and errors found in compose file.
+func printIssues(issues []container.Issue) {
+	if len(issues) == 0 {
+		return
+	}
+	color.Magenta("\nCompose file issues:")
+	for _, issue := range issues {
+		if issue.Severity == container.SeverityError {
+			color.Red("  " + issue.String())
+		} else {
+			color.Yellow("  " + issue.String())
+		}
+	}
+}
+
+func truncate(str string, n int) string {
+	if len(str) <= n {
+		return str
+	}
+	return str[:n-3] + "..."
+}
+
+// shortDigest returns digest part of pinned image ref, shortened
+// like image IDs in docker, or "-" if image is not pinned.
+func shortDigest(ref *string) string {
+	if ref == nil {
+		return "-"
+	}
+	_, digest, _ := strings.Cut(*ref, "@")
+	// "sha256:" and 12 hex digits
+	return digest[:min(len(digest), 19)]
+}
+
+// shortID returns image ID without "sha256:", shortened to 12 hex digits.
+func shortID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	return id[:min(len(id), 12)]
+}
+
+// printLogLine prints log line after prefix to stdout, or in red
+// to stderr if container wrote it there.
+func printLogLine(entry *logsv1.LogStreamResponse, timestamps bool, prefix string) {
+	line := entry.GetLine()
+	if timestamps && entry.GetTimestamp() != nil {
+		line = entry.GetTimestamp().AsTime().Format(time.RFC3339Nano) + " " + line
+	}
+	if entry.GetStream() == logsv1.Stream_STREAM_STDERR {
+		fmt.Fprintln(os.Stderr, prefix+color.RedString(line))
+		return
+	}
+	fmt.Println(prefix + line)
+}
+
+var logPrefixColors = []color.Attribute{
+	color.FgCyan,
+	color.FgYellow,
+	color.FgGreen,
+	color.FgMagenta,
+	color.FgBlue,
+	color.FgHiCyan,
+	color.FgHiYellow,
+	color.FgHiGreen,
+	color.FgHiMagenta,
+	color.FgHiBlue,
+}
+
+// logPrefixes gives containers logs of a project are merged from
+// prefixes with their names, each in its own color, like docker compose logs.
+type logPrefixes struct {
+	projName string
+	colors   map[string]*color.Color
+	width    int
+}
+
+// prefix returns "<service>[_<replica>] | " for container, padded
+// to the longest name seen so far.
+func (p *logPrefixes) prefix(ctrName string) string {
+	name := strings.TrimPrefix(ctrName, p.projName+"_")
+	c, ok := p.colors[name]
+	if !ok {
+		c = color.New(logPrefixColors[len(p.colors)%len(logPrefixColors)])
+		p.colors[name] = c
+	}
+	p.width = max(p.width, len(name))
+	return c.Sprintf("%-*s | ", p.width, name)
+}
+
+func psService(projName string, svcName string) {
+	msg, status, err := reqDo("GET", "/projects/"+projName+"/services/"+svcName, &QueryParams{})
+	if err != nil {
+		color.Magenta(msg.Msg)
+		color.Red(err.Error())
+		os.Exit(1)
+	}
+	color.Magenta(fmt.Sprintf("HTTP status code %v", status))
+	if status != 200 {
+		color.Red(msg.Msg)
+		os.Exit(1)
+	}
+	var svcResp server.SvcResp
+	err = json.Unmarshal([]byte(msg.Msg), &svcResp)
+	if err != nil {
+		color.Red(err.Error())
+		os.Exit(1)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 1, 1, 1, ' ', 0)
+	fmt.Fprintf(w, "service\t%s\t\n", svcResp.Service.Name)
+	fmt.Fprintf(w, "image\t%s\t\n", svcResp.Service.Image)
+	if svcResp.Service.ImageDigest != nil {
+		fmt.Fprintf(w, "pinned digest\t%s\t\n", *svcResp.Service.ImageDigest)
+	} else {
+		fmt.Fprintf(w, "pinned digest\t%s\t\n", "- (follows tag)")
+	}
+	if svcResp.Service.Platform != nil {
+		fmt.Fprintf(w, "platform\t%s\t\n", *svcResp.Service.Platform)
+	} else {
+		fmt.Fprintf(w, "platform\t%s\t\n", "- (server's)")
+	}
+	fmt.Fprintf(w, "status\t%s\t\n", svcResp.Status)
+	fmt.Fprintf(w, "replicas\t%v\t\n", svcResp.Service.Replicas)
+	fmt.Fprintf(w, "restarts\t%v\t\n", svcResp.Service.ControllerKillCount)
+	fmt.Fprintf(w, "desired state\t%s\t\n", svcResp.Service.DesiredState)
+	fmt.Fprintf(w, "paused\t%v\t\n", svcResp.Service.Paused)
+	rs := svcResp.Reconcile
+	if rs == nil {
+		fmt.Fprintf(w, "last check\t%s\t\n", "never")
+	} else {
+		fmt.Fprintf(w, "last check\t%s\t\n", rs.LastCheck.Format(time.RFC3339))
+		fmt.Fprintf(w, "last action\t%s\t\n", rs.LastAction)
+		if rs.LastError != nil {
+			fmt.Fprintf(w, "last error\t%s\t\n", *rs.LastError)
+		}
+		if rs.ContainerID != nil {
+			fmt.Fprintf(w, "container id\t%s\t\n", *rs.ContainerID)
+		}
+		if rs.ImageDigest != nil {
+			fmt.Fprintf(w, "image digest\t%s\t\n", *rs.ImageDigest)
+		}
+		if rs.ExitCode != nil {
+			fmt.Fprintf(w, "exit code\t%v\t\n", *rs.ExitCode)
+		}
+	}
+	err = w.Flush()
+	if err != nil {
+		color.Red(err.Error())
+		os.Exit(1)
+	}
+}
+
 func Run() {
 	createCmd := flag.NewFlagSet("create", flag.ExitOnError)
 	initHttpClient()
@@ -178,6 +987,9 @@ func Run() {
 		checkServerVer()
 		projName := createCmd.String("n", "", "project name to create")
 		composeFile := createCmd.String("c", "docker-compose.yml", "compose file to upload")
+		strict := createCmd.Bool("strict", false, "reject compose file using features plasma does not support")
+		wait := createCmd.Bool("wait", false, "deploy services right away and wait until they are healthy")
+		timeout := createCmd.Duration("timeout", 5*time.Minute, "how long to wait for healthy services")
 		createCmd.Parse(os.Args[2:])
 		if projName == nil {
 			color.Magenta(usage)
@@ -189,17 +1001,105 @@ func Run() {
 			color.Red(wrongOrMissingParameters)
 			os.Exit(1)
 		}
-		color.Magenta(fmt.Sprintf("Creating project %s...\n\n", *projName))
+		color.Magenta(fmt.Sprintf("Creating project %s...\n\n", *projName))
+		compoBytes, err := os.ReadFile(*composeFile)
+		if err != nil {
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		composeB64 := base64.RawURLEncoding.EncodeToString(compoBytes)
+		uploadBuilds(*projName, *composeFile, composeB64)
+		client.Timeout = resolveTimeout
+		msg, status, err := reqDo(
+			"POST",
+			"/create",
+			&QueryParams{
+				Compose: &composeB64,
+				Project: projName,
+				Extra:   map[string]string{"author": author(), "strict": strconv.FormatBool(*strict)},
+			},
+		)
+		if err != nil {
+			color.Magenta(msg.Msg)
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		color.Magenta(fmt.Sprintf("HTTP status code %v", status))
+		if status != 201 {
+			color.Red(msg.Msg)
+			printIssues(msg.Issues)
+			os.Exit(1)
+		}
+		color.Magenta(msg.Msg)
+		printIssues(msg.Issues)
+		if *wait {
+			deployWait(*projName, *timeout)
+		}
+	case "apply":
+		checkServerVer()
+		applyCmd := flag.NewFlagSet("apply", flag.ExitOnError)
+		projName := applyCmd.String("n", "", "project name to update")
+		composeFile := applyCmd.String("c", "docker-compose.yml", "compose file to upload")
+		strict := applyCmd.Bool("strict", false, "reject compose file using features plasma does not support")
+		applyCmd.Parse(os.Args[2:])
+		if *projName == "" {
+			color.Magenta(usage)
+			color.Red(wrongOrMissingParameters)
+			os.Exit(1)
+		}
+		color.Magenta(fmt.Sprintf("Applying project %s...\n\n", *projName))
+		compoBytes, err := os.ReadFile(*composeFile)
+		if err != nil {
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		composeB64 := base64.RawURLEncoding.EncodeToString(compoBytes)
+		uploadBuilds(*projName, *composeFile, composeB64)
+		client.Timeout = resolveTimeout
+		msg, status, err := reqDo(
+			"POST",
+			"/apply",
+			&QueryParams{
+				Compose: &composeB64,
+				Project: projName,
+				Extra:   map[string]string{"author": author(), "strict": strconv.FormatBool(*strict)},
+			},
+		)
+		if err != nil {
+			color.Magenta(msg.Msg)
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		color.Magenta(fmt.Sprintf("HTTP status code %v", status))
+		if status != 200 {
+			color.Red(msg.Msg)
+			printIssues(msg.Issues)
+			os.Exit(1)
+		}
+		color.Magenta(msg.Msg)
+		printIssues(msg.Issues)
+	case "plan":
+		checkServerVer()
+		planCmd := flag.NewFlagSet("plan", flag.ExitOnError)
+		projName := planCmd.String("n", "", "project name to plan for")
+		composeFile := planCmd.String("c", "docker-compose.yml", "compose file to plan")
+		planCmd.Parse(os.Args[2:])
+		if *projName == "" {
+			color.Magenta(usage)
+			color.Red(wrongOrMissingParameters)
+			os.Exit(1)
+		}
 		compoBytes, err := os.ReadFile(*composeFile)
 		if err != nil {
 			color.Red(err.Error())
 			os.Exit(1)
 		}
 		composeB64 := base64.RawURLEncoding.EncodeToString(compoBytes)
+		client.Timeout = resolveTimeout
 		msg, status, err := reqDo(
 			"POST",
-			"/create",
-			&QueryParams{Compose: &composeB64, Project: projName},
+			"/projects/"+*projName+"/plan",
+			&QueryParams{Compose: &composeB64},
 		)
 		if err != nil {
 			color.Magenta(msg.Msg)
@@ -207,13 +1107,177 @@ func Run() {
 			os.Exit(1)
 		}
 		color.Magenta(fmt.Sprintf("HTTP status code %v", status))
-		if status != 201 {
+		if status != 200 {
+			color.Red(msg.Msg)
+			os.Exit(1)
+		}
+		var plan server.PlanResp
+		err = json.Unmarshal([]byte(msg.Msg), &plan)
+		if err != nil {
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		printChanges(plan.Changes)
+		printIssues(plan.Issues)
+	case "history":
+		checkServerVer()
+		historyCmd := flag.NewFlagSet("history", flag.ExitOnError)
+		projName := historyCmd.String("n", "", "project name")
+		historyCmd.Parse(os.Args[2:])
+		if *projName == "" {
+			color.Magenta(usage)
+			color.Red(wrongOrMissingParameters)
+			os.Exit(1)
+		}
+		msg, status, err := reqDo("GET", "/projects/"+*projName+"/revisions", &QueryParams{})
+		if err != nil {
+			color.Magenta(msg.Msg)
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		color.Magenta(fmt.Sprintf("HTTP status code %v", status))
+		if status != 200 {
+			color.Red(msg.Msg)
+			os.Exit(1)
+		}
+		var revs []db.Revision
+		err = json.Unmarshal([]byte(msg.Msg), &revs)
+		if err != nil {
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 1, 1, 1, ' ', 0)
+		fmt.Fprintln(w, "rev\t|\tcreated_at\t|\tsource\t|\tauthor\t|\tdigests\t")
+		fmt.Fprintln(w, "---\t|\t---\t|\t---\t|\t---\t|\t---\t")
+		for _, rev := range revs {
+			var digests map[string]string
+			err := json.Unmarshal([]byte(rev.Digests), &digests)
+			if err != nil {
+				color.Red(err.Error())
+				os.Exit(1)
+			}
+			fmt.Fprintf(
+				w,
+				"%v\t|\t%s\t|\t%s\t|\t%s\t|\t%v\t\n",
+				rev.Number,
+				rev.CreatedAt.Format(time.RFC3339),
+				rev.Source,
+				rev.Author,
+				len(digests),
+			)
+		}
+		err = w.Flush()
+		if err != nil {
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+	case "diff":
+		checkServerVer()
+		diffCmd := flag.NewFlagSet("diff", flag.ExitOnError)
+		projName := diffCmd.String("n", "", "project name")
+		var revs revsFlag
+		diffCmd.Var(&revs, "rev", "revision to compare, given twice")
+		diffCmd.Parse(os.Args[2:])
+		if *projName == "" || len(revs) != 2 {
+			color.Magenta(usage)
+			color.Red(wrongOrMissingParameters)
+			os.Exit(1)
+		}
+		msg, status, err := reqDo(
+			"GET",
+			"/projects/"+*projName+"/diff",
+			&QueryParams{Extra: map[string]string{"from": revs[0], "to": revs[1]}},
+		)
+		if err != nil {
+			color.Magenta(msg.Msg)
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		color.Magenta(fmt.Sprintf("HTTP status code %v", status))
+		if status != 200 {
+			color.Red(msg.Msg)
+			os.Exit(1)
+		}
+		var changes []db.Change
+		err = json.Unmarshal([]byte(msg.Msg), &changes)
+		if err != nil {
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		printChanges(changes)
+	case "rollback":
+		checkServerVer()
+		rollbackCmd := flag.NewFlagSet("rollback", flag.ExitOnError)
+		projName := rollbackCmd.String("n", "", "project name")
+		to := rollbackCmd.String("to", "", "revision to roll back to")
+		rollbackCmd.Parse(os.Args[2:])
+		if *projName == "" || *to == "" {
+			color.Magenta(usage)
+			color.Red(wrongOrMissingParameters)
+			os.Exit(1)
+		}
+		client.Timeout = resolveTimeout
+		msg, status, err := reqDo(
+			"POST",
+			"/projects/"+*projName+"/rollback",
+			&QueryParams{Extra: map[string]string{"to": *to, "author": author()}},
+		)
+		if err != nil {
+			color.Magenta(msg.Msg)
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		color.Magenta(fmt.Sprintf("HTTP status code %v", status))
+		if status != 200 {
+			color.Red(msg.Msg)
+			printIssues(msg.Issues)
+			os.Exit(1)
+		}
+		color.Magenta(msg.Msg)
+		printIssues(msg.Issues)
+	case "update-images":
+		checkServerVer()
+		updateCmd := flag.NewFlagSet("update-images", flag.ExitOnError)
+		projName := updateCmd.String("n", "", "project name")
+		updateCmd.Parse(os.Args[2:])
+		if *projName == "" {
+			color.Magenta(usage)
+			color.Red(wrongOrMissingParameters)
+			os.Exit(1)
+		}
+		client.Timeout = resolveTimeout
+		msg, status, err := reqDo(
+			"POST",
+			"/projects/"+*projName+"/update-images",
+			&QueryParams{Services: updateCmd.Args(), Extra: map[string]string{"author": author()}},
+		)
+		if err != nil {
+			color.Magenta(msg.Msg)
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		color.Magenta(fmt.Sprintf("HTTP status code %v", status))
+		if status != 200 {
 			color.Red(msg.Msg)
+			printIssues(msg.Issues)
 			os.Exit(1)
 		}
 		color.Magenta(msg.Msg)
+		printIssues(msg.Issues)
 	case "ps":
 		checkServerVer()
+		psCmd := flag.NewFlagSet("ps", flag.ExitOnError)
+		projName := psCmd.String("n", "", "project name of the service")
+		psCmd.Parse(os.Args[2:])
+		if *projName != "" {
+			if psCmd.NArg() != 1 {
+				color.Magenta(usage)
+				color.Red(wrongOrMissingParameters)
+				os.Exit(1)
+			}
+			psService(*projName, psCmd.Arg(0))
+			return
+		}
 		msg, status, err := reqDo("GET", "/ps", &QueryParams{})
 		if err != nil {
 			color.Magenta(msg.Msg)
@@ -232,19 +1296,21 @@ func Run() {
 			color.Red(err.Error())
 			os.Exit(1)
 		}
-		fmt.Fprintln(w, "project\t|\tcreated_at\t")
-		fmt.Fprintln(w, "---\t|\t---\t")
+		fmt.Fprintln(w, "project\t|\tcreated_at\t|\tdesired\t|\tpaused\t")
+		fmt.Fprintln(w, "---\t|\t---\t|\t---\t|\t---\t")
 		for _, proj := range psResp.Projects {
 			fmt.Fprintf(
 				w,
-				"%s\t|\t%s\t\n",
+				"%s\t|\t%s\t|\t%s\t|\t%v\t\n",
 				proj.Name,
 				proj.CreatedAt.Format(time.RFC3339),
+				proj.DesiredState,
+				proj.Paused,
 			)
 		}
 		fmt.Fprintf(w, "\n")
-		fmt.Fprintln(w, "svc\t|\tproj\t|\timg\t|\tstatus\t|\tports\t|\tmounts\t|\trestarts\t")
-		fmt.Fprintln(w, "---\t|\t---\t|\t---\t|\t---\t|\t---\t|\t---\t|\t---\t")
+		fmt.Fprintln(w, "svc\t|\tproj\t|\timg\t|\tdigest\t|\tstatus\t|\tports\t|\tmounts\t|\trestarts\t|\tlast action\t|\terror\t")
+		fmt.Fprintln(w, "---\t|\t---\t|\t---\t|\t---\t|\t---\t|\t---\t|\t---\t|\t---\t|\t---\t|\t---\t")
 		for _, svc := range psResp.Services {
 			var ctrStatus string
 			for _, s := range psResp.Statuses {
@@ -291,16 +1357,270 @@ func Run() {
 				}
 				vols = len(volsFromDB)
 			}
+			if svc.DesiredState == db.StateStopped {
+				ctrStatus += " (stopped)"
+			}
+			if svc.Paused {
+				ctrStatus += " (paused)"
+			}
+			lastAction := "-"
+			lastErr := "-"
+			for _, rs := range psResp.Reconciles {
+				if rs.ServiceId == svc.ID {
+					lastAction = rs.LastAction
+					if rs.LastError != nil {
+						lastErr = truncate(*rs.LastError, 40)
+					}
+				}
+			}
 			fmt.Fprintf(
 				w,
-				"%s\t|\t%s\t|\t%s\t|\t%s\t|\t%s\t|\t%v\t|\t%v\t\n",
+				"%s\t|\t%s\t|\t%s\t|\t%s\t|\t%s\t|\t%s\t|\t%v\t|\t%v\t|\t%s\t|\t%s\t\n",
 				svc.Name,
 				projName,
 				svc.Image,
+				shortDigest(svc.ImageDigest),
 				ctrStatus,
 				ports,
 				vols,
 				svc.ControllerKillCount,
+				lastAction,
+				lastErr,
+			)
+		}
+		err = w.Flush()
+		if err != nil {
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+	case "stop", "start":
+		checkServerVer()
+		projectAction(os.Args[1], "/"+os.Args[1])
+		color.Magenta("Controller will converge to it on its next run.")
+	case "scale":
+		checkServerVer()
+		scaleCmd := flag.NewFlagSet("scale", flag.ExitOnError)
+		projName := scaleCmd.String("n", "", "project name")
+		scaleCmd.Parse(os.Args[2:])
+		if *projName == "" || scaleCmd.NArg() == 0 {
+			color.Magenta(usage)
+			color.Red(wrongOrMissingParameters)
+			os.Exit(1)
+		}
+		failed := false
+		for _, arg := range scaleCmd.Args() {
+			svcName, replicas, found := strings.Cut(arg, "=")
+			if !found || svcName == "" || replicas == "" {
+				color.Red(fmt.Sprintf("'%s' is not in <service>=<replicas> form", arg))
+				failed = true
+				continue
+			}
+			msg, status, err := reqDo(
+				"POST",
+				"/projects/"+*projName+"/services/"+svcName+"/scale",
+				&QueryParams{Extra: map[string]string{"replicas": replicas}},
+			)
+			if err != nil {
+				color.Red(err.Error())
+				failed = true
+				continue
+			}
+			if status != 200 {
+				color.Red(fmt.Sprintf("HTTP status code %v: %s", status, msg.Msg))
+				failed = true
+				continue
+			}
+			color.Magenta(msg.Msg)
+		}
+		if failed {
+			os.Exit(1)
+		}
+	case "events":
+		checkServerVer()
+		eventsCmd := flag.NewFlagSet("events", flag.ExitOnError)
+		projName := eventsCmd.String("n", "", "project name")
+		limit := eventsCmd.Int("limit", 50, "how many events to show")
+		eventsCmd.Parse(os.Args[2:])
+		qp := QueryParams{Extra: map[string]string{"limit": strconv.Itoa(*limit)}}
+		if *projName != "" {
+			qp.Project = projName
+		}
+		msg, status, err := reqDo("GET", "/events", &qp)
+		if err != nil {
+			color.Magenta(msg.Msg)
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		color.Magenta(fmt.Sprintf("HTTP status code %v", status))
+		if status != 200 {
+			color.Red(msg.Msg)
+			os.Exit(1)
+		}
+		var events []db.Event
+		err = json.Unmarshal([]byte(msg.Msg), &events)
+		if err != nil {
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 1, 1, 1, ' ', 0)
+		fmt.Fprintln(w, "time\t|\tkind\t|\tmessage\t")
+		fmt.Fprintln(w, "---\t|\t---\t|\t---\t")
+		for _, e := range events {
+			fmt.Fprintf(w, "%s\t|\t%s\t|\t%s\t\n", e.CreatedAt.Format(time.RFC3339), e.Kind, e.Message)
+		}
+		err = w.Flush()
+		if err != nil {
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+	case "restart":
+		checkServerVer()
+		restartCmd := flag.NewFlagSet("restart", flag.ExitOnError)
+		projName := restartCmd.String("n", "", "project name")
+		pull := restartCmd.Bool("pull", false, "pull images again before starting")
+		timeout := restartCmd.Duration("timeout", 60*time.Second, "how long to wait for healthy service")
+		restartCmd.Parse(os.Args[2:])
+		if *projName == "" {
+			color.Magenta(usage)
+			color.Red(wrongOrMissingParameters)
+			os.Exit(1)
+		}
+		svcNames := restartCmd.Args()
+		if len(svcNames) == 0 {
+			svcNames = projectServices(*projName)
+		}
+		client.Timeout = *timeout + 30*time.Second
+		failed := false
+		for _, svcName := range svcNames {
+			color.Magenta(fmt.Sprintf("Restarting %s...", svcName))
+			msg, status, err := reqDo(
+				"POST",
+				"/projects/"+*projName+"/services/"+svcName+"/restart",
+				&QueryParams{Extra: map[string]string{
+					"pull":    strconv.FormatBool(*pull),
+					"timeout": timeout.String(),
+				}},
+			)
+			if err != nil {
+				color.Red(err.Error())
+				failed = true
+				continue
+			}
+			if status != 200 {
+				color.Red(fmt.Sprintf("HTTP status code %v: %s", status, msg.Msg))
+				failed = true
+				continue
+			}
+			color.Magenta(msg.Msg)
+		}
+		if failed {
+			os.Exit(1)
+		}
+	case "pause", "unpause":
+		checkServerVer()
+		projectAction(os.Args[1], "/"+os.Args[1])
+	case "prune":
+		checkServerVer()
+		pruneCmd := flag.NewFlagSet("prune", flag.ExitOnError)
+		pruneContainers := pruneCmd.Bool("containers", false, "remove orphaned containers")
+		pruneVolumes := pruneCmd.Bool("volumes", false, "remove orphaned volumes")
+		pruneNetworks := pruneCmd.Bool("networks", false, "remove orphaned project networks")
+		pruneImages := pruneCmd.Bool("images", false, "remove dangling images")
+		dryRun := pruneCmd.Bool("dry-run", false, "only list what would be removed")
+		pruneCmd.Parse(os.Args[2:])
+		if !*pruneContainers && !*pruneVolumes && !*pruneNetworks && !*pruneImages {
+			*pruneContainers = true
+			*pruneNetworks = true
+			*pruneImages = true
+		}
+		msg, status, err := reqDo("POST", "/prune", &QueryParams{Extra: map[string]string{
+			"containers": strconv.FormatBool(*pruneContainers),
+			"volumes":    strconv.FormatBool(*pruneVolumes),
+			"networks":   strconv.FormatBool(*pruneNetworks),
+			"images":     strconv.FormatBool(*pruneImages),
+			"dry_run":    strconv.FormatBool(*dryRun),
+		}})
+		if err != nil {
+			color.Magenta(msg.Msg)
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		color.Magenta(fmt.Sprintf("HTTP status code %v", status))
+		if status != 200 {
+			color.Red(msg.Msg)
+			os.Exit(1)
+		}
+		var removed controller.Orphans
+		err = json.Unmarshal([]byte(msg.Msg), &removed)
+		if err != nil {
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		verb := "Removed"
+		if *dryRun {
+			verb = "Would remove"
+		}
+		for _, ctr := range removed.Containers {
+			color.Magenta(fmt.Sprintf("%s container %s (%s)", verb, ctr.Name, ctr.State))
+		}
+		for _, vol := range removed.Volumes {
+			color.Magenta(fmt.Sprintf("%s volume %s", verb, vol))
+		}
+		for _, net := range removed.Networks {
+			color.Magenta(fmt.Sprintf("%s network %s", verb, net))
+		}
+		for _, img := range removed.Images {
+			color.Magenta(fmt.Sprintf("%s image %s (%v bytes)", verb, img.ID, img.Size))
+		}
+		color.Magenta(fmt.Sprintf(
+			"%s %v containers, %v volumes, %v networks, %v images.",
+			verb,
+			len(removed.Containers),
+			len(removed.Volumes),
+			len(removed.Networks),
+			len(removed.Images),
+		))
+	case "images":
+		checkServerVer()
+		msg, status, err := reqDo("GET", "/images", &QueryParams{})
+		if err != nil {
+			color.Magenta(msg.Msg)
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		color.Magenta(fmt.Sprintf("HTTP status code %v", status))
+		if status != 200 {
+			color.Red(msg.Msg)
+			os.Exit(1)
+		}
+		var imgs []controller.ManagedImage
+		err = json.Unmarshal([]byte(msg.Msg), &imgs)
+		if err != nil {
+			color.Red(err.Error())
+			os.Exit(1)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 1, 1, 1, ' ', 0)
+		fmt.Fprintln(w, "ref\t|\tid\t|\tsource\t|\tsize\t|\tupdated_at\t|\tstatus\t|\tservices\t")
+		fmt.Fprintln(w, "---\t|\t---\t|\t---\t|\t---\t|\t---\t|\t---\t|\t---\t")
+		for _, img := range imgs {
+			ref := img.Ref
+			if name, _, pinned := strings.Cut(ref, "@"); pinned {
+				ref = name + "@" + shortDigest(&img.Ref)
+			}
+			services := "-"
+			if len(img.Services) > 0 {
+				services = strings.Join(img.Services, ",")
+			}
+			fmt.Fprintf(
+				w,
+				"%s\t|\t%s\t|\t%s\t|\t%s\t|\t%s\t|\t%s\t|\t%s\t\n",
+				ref,
+				shortID(img.ID),
+				img.Source,
+				units.HumanSize(float64(img.Size)),
+				img.UpdatedAt.Format(time.RFC3339),
+				img.Status,
+				services,
 			)
 		}
 		err = w.Flush()
@@ -308,6 +1628,21 @@ func Run() {
 			color.Red(err.Error())
 			os.Exit(1)
 		}
+		color.Magenta(fmt.Sprintf(
+			"%v images take %s.", len(imgs), units.HumanSize(float64(controller.ImagesSize(imgs))),
+		))
+	case "image":
+		checkServerVer()
+		if len(os.Args) < 4 || os.Args[2] != "push" {
+			color.Magenta(usage)
+			color.Red(wrongOrMissingParameters)
+			os.Exit(1)
+		}
+		color.Magenta(fmt.Sprintf("Pushing %s...\n\n", strings.Join(os.Args[3:], ", ")))
+		pushImages(os.Args[3:])
+	case "registry":
+		checkServerVer()
+		registryCmd()
 	case "serve":
 		color.Magenta("Deploying plasma...\n")
 		tempFile, err := os.CreateTemp("", "docker-compose.plasma.*.yml")
@@ -368,43 +1703,79 @@ func Run() {
 		color.Magenta("Plasma removed.")
 	case "logs":
 		checkServerVer()
-		var ctrName string
-		if len(os.Args) > 2 {
-			ctrName = os.Args[2]
+		logsCmd := flag.NewFlagSet("logs", flag.ExitOnError)
+		follow := logsCmd.Bool("f", false, "follow log output")
+		tail := logsCmd.String("tail", "all", "number of lines to show from the end of the logs")
+		since := logsCmd.String("since", "", "show logs since timestamp or relative, e.g. 10m")
+		until := logsCmd.String("until", "", "show logs before timestamp or relative, e.g. 10m")
+		timestamps := logsCmd.Bool("t", false, "show timestamps")
+		stdout := logsCmd.Bool("stdout", false, "show only stdout, unless --stderr is set too")
+		stderr := logsCmd.Bool("stderr", false, "show only stderr, unless --stdout is set too")
+		projName := logsCmd.String("n", "", "project to show logs of all its services from")
+		logsCmd.Parse(os.Args[2:])
+		if *projName == "" && logsCmd.NArg() > 1 {
+			color.Magenta(usage)
+			color.Red(wrongOrMissingParameters)
+			os.Exit(1)
+		}
+		ctrName := "plasma-server"
+		var services []string
+		if *projName != "" {
+			ctrName = ""
+			services = logsCmd.Args()
+		} else if logsCmd.NArg() == 1 {
+			ctrName = logsCmd.Arg(0)
 			if ctrName == "" {
 				color.Magenta(usage)
 				color.Red(wrongOrMissingParameters)
 				os.Exit(1)
 			}
-		} else {
-			ctrName = "plasma-server"
 		}
+		if _, err := strconv.Atoi(*tail); err != nil && *tail != "all" {
+			color.Red("--tail must be a number of lines or 'all'")
+			os.Exit(1)
+		}
+		// followed stream lasts until interrupted
+		streamClient := *client
+		streamClient.Timeout = 0
 		grpcClient := logsv1connect.NewLoggerServiceClient(
-			client,
+			&streamClient,
 			"http://localhost:8081", // TODO: read from env or some config file
 		)
-		ctx := context.Background()
+		// interrupting closes the stream, so plasma-server stops reading logs
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
 		stream, err := grpcClient.LogStream(ctx, connect.NewRequest(&logsv1.LogStreamRequest{
-			Name: ctrName,
+			Name:     ctrName,
+			Follow:   *follow,
+			Tail:     *tail,
+			Since:    *since,
+			Until:    *until,
+			Stdout:   *stdout,
+			Stderr:   *stderr,
+			Project:  *projName,
+			Services: services,
 		}))
 		if err != nil {
 			color.Red(err.Error())
 			os.Exit(1)
 		}
+		prefixes := &logPrefixes{projName: *projName, colors: map[string]*color.Color{}}
 		for {
 			more := stream.Receive()
 			if !more {
 				color.Magenta("log stream finished")
-				if stream.Err() != nil {
+				if stream.Err() != nil && ctx.Err() == nil {
 					color.Red(stream.Err().Error())
 					os.Exit(1)
 				}
 				break
 			}
-
-			// [8:] to remove stream metadata
-			// https://pkg.go.dev/github.com/docker/docker/client#Client.ContainerLogs
-			fmt.Println(string(stream.Msg().Message[8:]))
+			var prefix string
+			if *projName != "" {
+				prefix = prefixes.prefix(stream.Msg().GetContainer())
+			}
+			printLogLine(stream.Msg(), *timestamps, prefix)
 		}
 	case "help":
 		color.Magenta(usage)
@@ -0,0 +1,150 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/compose-spec/compose-go/v2/types"
+	"github.com/docker/docker/api/types/build"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/moby/go-archive"
+	"github.com/moby/patternmatcher/ignorefile"
+	"github.com/pgulb/plasma/db"
+)
+
+// BuildImageName returns name of images built for service, following compose.
+func BuildImageName(projName string, svcName string) string {
+	return projName + "-" + svcName
+}
+
+// BuildContext tars service's build context directory, leaving out
+// files matched by its .dockerignore.
+func BuildContext(svc *types.ServiceConfig) (io.ReadCloser, error) {
+	dir := svc.Build.Context
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("build context of %s: %w", svc.Name, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("build context of %s is not a directory: %s", svc.Name, dir)
+	}
+	var excludes []string
+	f, err := os.Open(filepath.Join(dir, ".dockerignore"))
+	if err == nil {
+		excludes, err = ignorefile.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	dockerfile := svc.Build.Dockerfile
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
+	// like docker, always send the dockerfile and .dockerignore,
+	// daemon needs them even if they are ignored
+	excludes = append(excludes, "!"+filepath.ToSlash(dockerfile), "!.dockerignore")
+	return archive.TarWithOptions(dir, &archive.TarOptions{ExcludePatterns: excludes})
+}
+
+// BuildImage builds image of service from its build context tar, writing
+// build output to out as json messages. Image is tagged with service's
+// image name if set, and with a tag unique to the build, which is returned
+// together with image ID.
+func BuildImage(
+	ctx context.Context,
+	project *types.Project,
+	svcName string,
+	buildCtx io.Reader,
+	out io.Writer,
+) (string, string, error) {
+	svc, ok := project.Services[svcName]
+	if !ok {
+		return "", "", fmt.Errorf("service %s not found in compose file", svcName)
+	}
+	if svc.Build == nil {
+		return "", "", fmt.Errorf("service %s has no build section", svcName)
+	}
+	name := BuildImageName(project.Name, svcName)
+	tags := append([]string{name + ":latest"}, svc.Build.Tags...)
+	if svc.Image != "" {
+		tags = append(tags, svc.Image)
+	}
+	labels := map[string]string{LabelBuild: project.Name + "_" + svcName}
+	for k, v := range svc.Build.Labels {
+		labels[k] = v
+	}
+	resp, err := Docker.ImageBuild(ctx, buildCtx, build.ImageBuildOptions{
+		Tags:        tags,
+		Dockerfile:  svc.Build.Dockerfile,
+		BuildArgs:   svc.Build.Args,
+		Target:      svc.Build.Target,
+		Labels:      labels,
+		NoCache:     svc.Build.NoCache,
+		PullParent:  svc.Build.Pull,
+		Remove:      true,
+		ForceRemove: true,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	var imageID string
+	dec := json.NewDecoder(resp.Body)
+	enc := json.NewEncoder(out)
+	for {
+		var msg jsonmessage.JSONMessage
+		err := dec.Decode(&msg)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", "", err
+		}
+		if msg.Aux != nil {
+			var result build.Result
+			if err := json.Unmarshal(*msg.Aux, &result); err == nil && result.ID != "" {
+				imageID = result.ID
+			}
+			continue
+		}
+		if err := enc.Encode(msg); err != nil {
+			return "", "", err
+		}
+		if msg.Error != nil {
+			return "", "", msg.Error
+		}
+		if f, ok := out.(interface{ Flush() }); ok {
+			f.Flush()
+		}
+	}
+	if imageID == "" {
+		return "", "", fmt.Errorf("build of %s did not return image ID", svcName)
+	}
+	image := fmt.Sprintf("%s:build-%s", name, shortID(imageID))
+	err = Docker.ImageTag(ctx, imageID, image)
+	if err != nil {
+		return "", "", err
+	}
+	err = db.SaveImage(&db.Image{Ref: image, ImageID: imageID, Source: db.ImageSourceBuild})
+	if err != nil {
+		return "", "", err
+	}
+	return imageID, image, nil
+}
+
+func shortID(imageID string) string {
+	_, id, found := strings.Cut(imageID, ":")
+	if !found {
+		id = imageID
+	}
+	return id[:min(12, len(id))]
+}
@@ -4,30 +4,58 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
-	"errors"
-	"io"
+	"fmt"
 	"log"
 	"os"
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/compose-spec/compose-go/v2/cli"
 	"github.com/compose-spec/compose-go/v2/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/api/types/volume"
 	dcr "github.com/docker/docker/client"
 	"github.com/docker/go-connections/nat"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pgulb/plasma/db"
 )
 
 var Docker *dcr.Client
 
-type LogResult struct {
-	Value []byte
-	Err   error
+// Labels put on every resource created by plasma, used to find
+// resources left behind by deleted projects or services.
+const (
+	LabelManaged = "plasma.managed"
+	LabelProject = "plasma.project"
+	LabelService = "plasma.service"
+	// hash of service's spec container was created from, see db.Service.SpecHash
+	LabelSpecHash = "plasma.spec-hash"
+	// service image was built for, only on images, as containers inherit
+	// labels of their images and other labels would make containers
+	// started outside of plasma look managed by it
+	LabelBuild = "plasma.build"
+)
+
+// ReplicaNames returns container names of all service's replicas:
+// '<project>_<svc>_<i>' for the i-th one, except the first, which keeps
+// name '<project>_<svc>' services had before replicas, so its container
+// is not replaced when service is scaled up or down.
+func ReplicaNames(svc *db.Service) []string {
+	names := []string{svc.Name}
+	for i := 2; i <= int(svc.Replicas); i++ {
+		names = append(names, svc.Name+"_"+strconv.Itoa(i))
+	}
+	return names
+}
+
+// NetworkName returns name of the network shared by project's containers.
+func NetworkName(projName string) string {
+	return "plasma_" + projName
 }
 
 func Init() error {
@@ -40,46 +68,72 @@ func Init() error {
 	return nil
 }
 
-func ParseCompose(projName string, cmps string) (*types.Project, error) {
+func DecodeCompose(cmps string) ([]byte, error) {
 	decoded, err := base64.RawURLEncoding.DecodeString(cmps)
 	if err != nil {
 		log.Println(err)
 		return nil, err
 	}
-	tmp, err := os.CreateTemp("", "compose-*.txt")
+	return decoded, nil
+}
+
+func ParseCompose(projName string, cmps string, strict bool) (*types.Project, []Issue, error) {
+	decoded, err := DecodeCompose(cmps)
+	if err != nil {
+		return nil, nil, err
+	}
+	return LoadCompose(projName, decoded, strict)
+}
+
+// LoadComposeFile loads project from compose file on disk, resolving
+// relative paths, e.g. build contexts, against its directory.
+func LoadComposeFile(projName string, path string) (*types.Project, error) {
+	options, err := cli.NewProjectOptions(
+		[]string{path},
+		cli.WithName(projName),
+	)
 	if err != nil {
 		log.Println(err)
 		return nil, err
 	}
-	defer os.Remove(tmp.Name())
-	_, err = tmp.Write(decoded)
-	tmp.Close()
+	project, err := options.LoadProject(context.Background())
 	if err != nil {
 		log.Println(err)
 		return nil, err
 	}
-	ctx := context.Background()
+	return project, nil
+}
 
-	options, err := cli.NewProjectOptions(
-		[]string{tmp.Name()},
-		cli.WithName(projName),
-	)
+// LoadCompose loads project from contents of compose file and validates it.
+// Unless it fails to load, project is returned together with its issues,
+// even if they make it invalid. In strict mode any issue makes it invalid.
+func LoadCompose(projName string, compose []byte, strict bool) (*types.Project, []Issue, error) {
+	tmp, err := os.CreateTemp("", "compose-*.txt")
 	if err != nil {
 		log.Println(err)
-		return nil, err
+		return nil, nil, err
 	}
-
-	project, err := options.LoadProject(ctx)
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(compose)
+	tmp.Close()
 	if err != nil {
 		log.Println(err)
-		return nil, err
+		return nil, nil, err
+	}
+	project, err := LoadComposeFile(projName, tmp.Name())
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return project, nil
+	issues, err := Validate(project)
+	if err != nil {
+		log.Println(err)
+		return nil, nil, err
+	}
+	return project, issues, checkIssues(issues, strict)
 }
 
-func Get(name string) (*container.InspectResponse, error) {
-	ctx := context.Background()
+func Get(ctx context.Context, name string) (*container.InspectResponse, error) {
 	// TODO: probably sometimes ContainerList() panics for no known reason
 	// needs to be recovered
 	ctrs, err := Docker.ContainerList(ctx, container.ListOptions{All: true})
@@ -104,27 +158,30 @@ func Get(name string) (*container.InspectResponse, error) {
 	return &container, nil
 }
 
-func Run(svc *db.Service) error {
-	ctx := context.Background()
-	imgPresent, err := imagePresent(svc)
+// Run creates and starts container of service's replica called name.
+func Run(ctx context.Context, svc *db.Service, name string) error {
+	err := EnsureImage(ctx, svc, nil)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
-	if !imgPresent {
-		err := imgPull(svc)
-		if err != nil {
-			log.Println(err)
-			return err
-		}
+	ctrID, err := Create(ctx, svc, name)
+	if err != nil {
+		return err
 	}
+	return Start(ctx, ctrID)
+}
+
+// Create creates container of service's replica called name without
+// starting it, returning its ID. Service's image must be present.
+func Create(ctx context.Context, svc *db.Service, name string) (string, error) {
 	var volsFromDB []db.VolumeInDB
 	var binds []string
 	if svc.Volumes != nil {
 		err := json.Unmarshal([]byte(*svc.Volumes), &volsFromDB)
 		if err != nil {
 			log.Println(err)
-			return err
+			return "", err
 		}
 		for _, v := range volsFromDB {
 			binds = append(binds, v.Source+":"+v.Target)
@@ -137,7 +194,7 @@ func Run(svc *db.Service) error {
 		err := json.Unmarshal([]byte(*svc.Ports), &portsFromDB)
 		if err != nil {
 			log.Println(err)
-			return err
+			return "", err
 		}
 		for _, port := range portsFromDB {
 			portBindings[nat.Port(strconv.FormatUint(uint64(port.Target), 10))+"/tcp"] = []nat.PortBinding{
@@ -154,76 +211,185 @@ func Run(svc *db.Service) error {
 		err := json.Unmarshal([]byte(*svc.Environment), &envsFromDB)
 		if err != nil {
 			log.Println(err)
-			return err
+			return "", err
 		}
 		for k, v := range envsFromDB {
 			envs = append(envs, k+"="+v)
 		}
 	}
-	created, err := Docker.ContainerCreate(
-		ctx,
-		&container.Config{Image: svc.Image, Env: envs},
-		&container.HostConfig{Binds: binds, PortBindings: portBindings},
-		&network.NetworkingConfig{},
-		nil,
-		svc.Name,
-	)
+	projName, err := db.ProjectName(svc.ProjectId)
 	if err != nil {
 		log.Println(err)
-		return err
+		return "", err
 	}
-	err = Docker.ContainerStart(ctx, created.ID, container.StartOptions{})
+	netName, err := ensureNetwork(ctx, projName)
 	if err != nil {
 		log.Println(err)
-		return err
+		return "", err
 	}
-	return nil
-}
-
-func imgPull(svc *db.Service) error {
-	ctx := context.Background()
-	log.Println("Pulling image", svc.Image)
-	closer, err := Docker.ImagePull(ctx, svc.Image, image.PullOptions{})
+	labels := map[string]string{
+		LabelManaged:  "true",
+		LabelProject:  projName,
+		LabelService:  svc.Name,
+		LabelSpecHash: svc.SpecHash(),
+	}
+	// every replica gets compose service name as alias,
+	// so the alias resolves to all of them
+	alias := strings.TrimPrefix(svc.Name, projName+"_")
+	var platform *ocispec.Platform
+	if svc.Platform != nil {
+		p, err := ParsePlatform(*svc.Platform)
+		if err != nil {
+			return "", err
+		}
+		platform = &p
+	}
+	config := &container.Config{Image: svc.ImageRef(), Env: envs, Labels: labels}
+	err = setRunOptions(svc, config)
 	if err != nil {
 		log.Println(err)
-		return err
+		return "", err
 	}
-	defer closer.Close()
-	importCloser, err := Docker.ImageImport(
+	created, err := Docker.ContainerCreate(
 		ctx,
-		image.ImportSource{
-			SourceName: "-",
-			Source:     closer,
+		config,
+		&container.HostConfig{Binds: binds, PortBindings: portBindings},
+		&network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{
+				netName: {Aliases: []string{alias}},
+			},
 		},
-		svc.Image,
-		image.ImportOptions{},
+		platform,
+		name,
 	)
 	if err != nil {
-		// for some reason this is returned but it works
-		if !strings.Contains(err.Error(), "invalid tar header") {
-			log.Println(err)
+		log.Println(err)
+		return "", err
+	}
+	return created.ID, nil
+}
+
+// setRunOptions sets service's command, entrypoint, hostname,
+// exposed ports and healthcheck on container's config.
+func setRunOptions(svc *db.Service, config *container.Config) error {
+	if svc.Command != nil {
+		err := json.Unmarshal([]byte(*svc.Command), &config.Cmd)
+		if err != nil {
+			return err
+		}
+	}
+	if svc.Entrypoint != nil {
+		err := json.Unmarshal([]byte(*svc.Entrypoint), &config.Entrypoint)
+		if err != nil {
+			return err
+		}
+	}
+	if svc.Hostname != nil {
+		config.Hostname = *svc.Hostname
+	}
+	if svc.Expose != nil {
+		var expose []string
+		err := json.Unmarshal([]byte(*svc.Expose), &expose)
+		if err != nil {
 			return err
 		}
+		config.ExposedPorts = nat.PortSet{}
+		for _, e := range expose {
+			proto, ports := nat.SplitProtoPort(e)
+			start, end, err := nat.ParsePortRangeToInt(ports)
+			if err != nil {
+				return fmt.Errorf("expose %s: %w", e, err)
+			}
+			for p := start; p <= end; p++ {
+				port, err := nat.NewPort(proto, strconv.Itoa(p))
+				if err != nil {
+					return fmt.Errorf("expose %s: %w", e, err)
+				}
+				config.ExposedPorts[port] = struct{}{}
+			}
+		}
 	}
-	if importCloser != nil {
-		defer importCloser.Close()
+	if svc.HealthCheckDisable != nil && *svc.HealthCheckDisable {
+		config.Healthcheck = &container.HealthConfig{Test: []string{"NONE"}}
+		return nil
+	}
+	health := &container.HealthConfig{}
+	if svc.HealthCheckCmd != nil {
+		err := json.Unmarshal([]byte(*svc.HealthCheckCmd), &health.Test)
+		if err != nil {
+			// stored joined with spaces by older versions
+			cmd := strings.TrimPrefix(strings.TrimPrefix(*svc.HealthCheckCmd, "CMD-SHELL "), "CMD ")
+			health.Test = []string{"CMD-SHELL", cmd}
+		}
+	}
+	if svc.HealthCheckTimeout != nil {
+		health.Timeout = *svc.HealthCheckTimeout
+	}
+	if svc.HealthCheckInterval != nil {
+		health.Interval = *svc.HealthCheckInterval
+	}
+	if svc.HealthCheckRetries != nil {
+		health.Retries = int(*svc.HealthCheckRetries)
+	}
+	if svc.HealthCheckStartPeriod != nil {
+		health.StartPeriod = *svc.HealthCheckStartPeriod
+	}
+	if svc.HealthCheckStartInterval != nil {
+		health.StartInterval = *svc.HealthCheckStartInterval
+	}
+	// image's healthcheck is kept if service does not override it
+	if health.Test != nil || health.Timeout != 0 || health.Interval != 0 ||
+		health.Retries != 0 || health.StartPeriod != 0 || health.StartInterval != 0 {
+		config.Healthcheck = health
 	}
 	return nil
 }
 
-func imagePresent(svc *db.Service) (bool, error) {
-	ctx := context.Background()
-	images, err := Docker.ImageList(ctx, image.ListOptions{All: true})
+func Start(ctx context.Context, ctrID string) error {
+	err := Docker.ContainerStart(ctx, ctrID, container.StartOptions{})
 	if err != nil {
 		log.Println(err)
-		return false, err
+		return err
 	}
-	for _, img := range images {
-		if slices.Contains(img.RepoTags, svc.Image) {
-			return true, nil
+	return nil
+}
+
+// ensureNetwork creates project's network if it does not exist yet.
+func ensureNetwork(ctx context.Context, projName string) (string, error) {
+	netName := NetworkName(projName)
+	nets, err := Docker.NetworkList(ctx, network.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("name", netName)),
+	})
+	if err != nil {
+		return "", err
+	}
+	for _, n := range nets {
+		if n.Name == netName {
+			return netName, nil
 		}
 	}
-	return false, nil
+	log.Println("Creating network", netName)
+	_, err = Docker.NetworkCreate(ctx, netName, network.CreateOptions{
+		Driver: "bridge",
+		Labels: map[string]string{LabelManaged: "true", LabelProject: projName},
+	})
+	if err != nil {
+		return "", err
+	}
+	return netName, nil
+}
+
+// ImageDigest returns repo digest of the image if known, else its ID.
+func ImageDigest(ctx context.Context, imgID string) (string, error) {
+	img, err := Docker.ImageInspect(ctx, imgID)
+	if err != nil {
+		log.Println(err)
+		return "", err
+	}
+	if len(img.RepoDigests) > 0 {
+		return img.RepoDigests[0], nil
+	}
+	return img.ID, nil
 }
 
 func IsPresentAliveAndHealthy(
@@ -248,8 +414,77 @@ func IsPresentAliveAndHealthy(
 	}
 }
 
-func Volume(volName string) (bool, error) {
-	ctx := context.Background()
+// IsStarting reports if running container's healthcheck did not pass yet,
+// e.g. during its start period, so it's neither healthy nor unhealthy.
+func IsStarting(ctr *container.InspectResponse) bool {
+	return ctr != nil && ctr.State != nil && ctr.State.Health != nil &&
+		ctr.State.Health.Status == container.Starting
+}
+
+// WaitHealthy polls container until it is running and healthy,
+// returning an error if it stops or ctx expires first.
+func WaitHealthy(ctx context.Context, svc *db.Service, name string) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		ctr, err := Get(ctx, name)
+		if err != nil {
+			return err
+		}
+		present, alive, healthy := IsPresentAliveAndHealthy(svc, ctr)
+		if !present {
+			return fmt.Errorf("container %s is gone", name)
+		}
+		if !alive {
+			return fmt.Errorf("container %s is not running (exit code %v)", name, ctr.State.ExitCode)
+		}
+		if healthy {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("container %s did not become healthy: %w", name, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stats samples container's CPU usage, where 100 is one full core,
+// and memory usage in percent of its limit.
+func Stats(ctx context.Context, ctrID string) (float64, float64, error) {
+	resp, err := Docker.ContainerStats(ctx, ctrID, false)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	var stats container.StatsResponse
+	err = json.NewDecoder(resp.Body).Decode(&stats)
+	if err != nil {
+		return 0, 0, err
+	}
+	var cpu float64
+	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(stats.PreCPUStats.CPUUsage.TotalUsage)
+	sysDelta := float64(stats.CPUStats.SystemUsage) - float64(stats.PreCPUStats.SystemUsage)
+	if cpuDelta > 0 && sysDelta > 0 {
+		cpus := float64(stats.CPUStats.OnlineCPUs)
+		if cpus == 0 {
+			cpus = float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+		}
+		cpu = cpuDelta / sysDelta * cpus * 100
+	}
+	var mem float64
+	if stats.MemoryStats.Limit > 0 {
+		// page cache can be reclaimed, so it does not count, same as in 'docker stats'
+		used := stats.MemoryStats.Usage
+		if cache := stats.MemoryStats.Stats["inactive_file"]; cache < used {
+			used -= cache
+		}
+		mem = float64(used) / float64(stats.MemoryStats.Limit) * 100
+	}
+	return cpu, mem, nil
+}
+
+func Volume(ctx context.Context, volName string) (bool, error) {
 	vols, err := Docker.VolumeList(ctx, volume.ListOptions{})
 	if err != nil {
 		log.Println(err)
@@ -263,9 +498,12 @@ func Volume(volName string) (bool, error) {
 	return false, nil
 }
 
-func VolumeCreate(volName string) error {
-	ctx := context.Background()
-	_, err := Docker.VolumeCreate(ctx, volume.CreateOptions{Name: volName, Driver: "local"})
+func VolumeCreate(ctx context.Context, volName string) error {
+	_, err := Docker.VolumeCreate(ctx, volume.CreateOptions{
+		Name:   volName,
+		Driver: "local",
+		Labels: map[string]string{LabelManaged: "true"},
+	})
 	if err != nil {
 		log.Println(err)
 		return err
@@ -273,53 +511,116 @@ func VolumeCreate(volName string) error {
 	return nil
 }
 
-func Kill(ctrID string) error {
-	ctx := context.Background()
-	err := Docker.ContainerRemove(ctx, ctrID, container.RemoveOptions{})
+// Kill removes container without stopping it gracefully first.
+func Kill(ctx context.Context, ctrID string) error {
+	err := Docker.ContainerRemove(ctx, ctrID, container.RemoveOptions{Force: true})
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func GoLogs(ctrName string, c chan LogResult) {
-	ctx := context.Background()
-	ctr, err := Get(ctrName)
+// ListManaged returns all containers labeled as created by plasma.
+func ListManaged(ctx context.Context) ([]container.Summary, error) {
+	ctrs, err := Docker.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", LabelManaged+"=true")),
+	})
 	if err != nil {
-		c <- LogResult{Err: err}
-		close(c)
-		return
+		log.Println(err)
+		return nil, err
 	}
-	if ctr == nil {
-		c <- LogResult{Err: errors.New("container not found")}
-		close(c)
-		return
+	return ctrs, nil
+}
+
+// ListService returns all containers of the service, including
+// replicas no longer wanted after scaling down.
+func ListService(ctx context.Context, svcName string) ([]container.Summary, error) {
+	ctrs, err := Docker.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", LabelService+"="+svcName)),
+	})
+	if err != nil {
+		log.Println(err)
+		return nil, err
 	}
-	rc, err := Docker.ContainerLogs(
-		ctx,
-		ctr.ID,
-		container.LogsOptions{ShowStdout: true, ShowStderr: true, Timestamps: true},
-	)
+	return ctrs, nil
+}
+
+// ListManagedVolumes returns all volumes labeled as created by plasma.
+func ListManagedVolumes(ctx context.Context) ([]*volume.Volume, error) {
+	vols, err := Docker.VolumeList(ctx, volume.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", LabelManaged+"=true")),
+	})
 	if err != nil {
 		log.Println(err)
-		c <- LogResult{Err: err}
-		close(c)
-		return
+		return nil, err
 	}
-	defer rc.Close()
-	for {
-		buf := make([]byte, 32)
-		n, err := rc.Read(buf)
-		if err != nil && err != io.EOF {
-			log.Println(err)
-			c <- LogResult{Err: err}
-			close(c)
-			return
-		}
-		if n == 0 {
-			break
-		}
-		c <- LogResult{Value: buf[:n]}
+	return vols.Volumes, nil
+}
+
+// ListManagedNetworks returns networks plasma created for projects.
+func ListManagedNetworks(ctx context.Context) ([]network.Summary, error) {
+	nets, err := Docker.NetworkList(ctx, network.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", LabelManaged+"=true")),
+	})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return nets, nil
+}
+
+func NetworkRemove(ctx context.Context, netName string) error {
+	return Docker.NetworkRemove(ctx, netName)
+}
+
+// DanglingImages returns untagged images, mostly left behind
+// by pulls of tags that moved to a newer image.
+func DanglingImages(ctx context.Context) ([]image.Summary, error) {
+	imgs, err := Docker.ImageList(ctx, image.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("dangling", "true")),
+	})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return imgs, nil
+}
+
+func ForceRemove(ctx context.Context, ctrID string) error {
+	err := Docker.ContainerRemove(ctx, ctrID, container.RemoveOptions{Force: true})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func VolumeRemove(ctx context.Context, volName string) error {
+	err := Docker.VolumeRemove(ctx, volName, false)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func ImageRemove(ctx context.Context, imgID string) error {
+	_, err := Docker.ImageRemove(ctx, imgID, image.RemoveOptions{PruneChildren: true})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func Rename(ctx context.Context, ctrID string, name string) error {
+	return Docker.ContainerRename(ctx, ctrID, name)
+}
+
+// Stop gracefully stops container and removes it.
+func Stop(ctx context.Context, ctrID string) error {
+	err := Docker.ContainerStop(ctx, ctrID, container.StopOptions{})
+	if err != nil {
+		return err
 	}
-	close(c)
+	return Docker.ContainerRemove(ctx, ctrID, container.RemoveOptions{})
 }
@@ -0,0 +1,49 @@
+package container
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+func inspect(state *container.State) *container.InspectResponse {
+	return &container.InspectResponse{ContainerJSONBase: &container.ContainerJSONBase{State: state}}
+}
+
+func withHealth(status container.HealthStatus) *container.State {
+	return &container.State{Running: true, Health: &container.Health{Status: status}}
+}
+
+func TestHealthStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		ctr      *container.InspectResponse
+		present  bool
+		alive    bool
+		healthy  bool
+		starting bool
+	}{
+		{"missing", nil, false, false, false, false},
+		{"exited", inspect(&container.State{ExitCode: 1}), true, false, false, false},
+		{"paused", inspect(&container.State{Running: true, Paused: true}), true, false, false, false},
+		{"restarting", inspect(&container.State{Running: true, Restarting: true}), true, false, false, false},
+		{"no healthcheck", inspect(&container.State{Running: true}), true, true, true, false},
+		{"starting", inspect(withHealth(container.Starting)), true, true, false, true},
+		{"healthy", inspect(withHealth(container.Healthy)), true, true, true, false},
+		{"unhealthy", inspect(withHealth(container.Unhealthy)), true, true, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			present, alive, healthy := IsPresentAliveAndHealthy(nil, tt.ctr)
+			if present != tt.present || alive != tt.alive || healthy != tt.healthy {
+				t.Errorf(
+					"present, alive, healthy = %v, %v, %v, want %v, %v, %v",
+					present, alive, healthy, tt.present, tt.alive, tt.healthy,
+				)
+			}
+			if starting := IsStarting(tt.ctr); starting != tt.starting {
+				t.Errorf("starting = %v, want %v", starting, tt.starting)
+			}
+		})
+	}
+}
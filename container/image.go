@@ -0,0 +1,433 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/compose-spec/compose-go/v2/types"
+	"github.com/containerd/errdefs"
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/pgulb/plasma/db"
+)
+
+// ImageProgress receives pull progress messages, one for every layer update.
+type ImageProgress func(msg jsonmessage.JSONMessage)
+
+// EnsureImage makes sure service's image is present before running
+// a container, pulling it according to service's pull policy.
+func EnsureImage(ctx context.Context, svc *db.Service, progress ImageProgress) error {
+	var policy string
+	if svc.PullPolicy != nil {
+		policy = *svc.PullPolicy
+	}
+	// daily, weekly and every_<duration> are refresh with interval
+	policy, interval, err := types.ServiceConfig{PullPolicy: policy}.GetPullPolicy()
+	if err != nil {
+		return err
+	}
+	// pinned digest never moves, so it's only pulled when missing
+	if svc.ImageDigest != nil {
+		present, err := presentFor(ctx, svc, *svc.ImageDigest)
+		if err != nil || present {
+			return err
+		}
+		return pull(ctx, svc, progress)
+	}
+	img, err := db.GetImage(svc.Image)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if img != nil && img.Source != db.ImageSourcePull {
+		present, err := imagePresent(ctx, svc.Image)
+		if err != nil {
+			return err
+		}
+		if !present {
+			return fmt.Errorf("image %s is gone and can't be pulled, %s it again", svc.Image, img.Source)
+		}
+		return nil
+	}
+	present, err := presentFor(ctx, svc, svc.Image)
+	if err != nil {
+		return err
+	}
+	switch policy {
+	case types.PullPolicyAlways:
+		return pull(ctx, svc, progress)
+	case types.PullPolicyNever, types.PullPolicyBuild:
+		if !present {
+			return fmt.Errorf("image %s is not present and pull_policy is %s", svc.Image, policy)
+		}
+		return nil
+	case types.PullPolicyRefresh:
+		if present && img != nil && time.Since(img.UpdatedAt) < interval {
+			return nil
+		}
+		return pull(ctx, svc, progress)
+	default:
+		if present {
+			return nil
+		}
+		return pull(ctx, svc, progress)
+	}
+}
+
+// Pull pulls service's image even if it's already present,
+// unless it was pushed or built by plasma.
+func Pull(ctx context.Context, svc *db.Service, progress ImageProgress) error {
+	img, err := db.GetImage(svc.Image)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if img != nil && img.Source != db.ImageSourcePull {
+		log.Println("Image", svc.Image, "comes from", img.Source, "and can't be pulled.")
+		return nil
+	}
+	return pull(ctx, svc, progress)
+}
+
+// pull pulls service's image, or its pinned digest, for service's platform
+// with project's registry credentials, reading the progress stream until
+// pull ends, and records when it was pulled.
+func pull(ctx context.Context, svc *db.Service, progress ImageProgress) error {
+	ref := svc.ImageRef()
+	auth, err := registryAuth(ref, svc.ProjectId)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	log.Println("Pulling image", ref)
+	var platform string
+	if svc.Platform != nil {
+		platform = *svc.Platform
+	}
+	stream, err := Docker.ImagePull(ctx, ref, image.PullOptions{RegistryAuth: auth, Platform: platform})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	defer stream.Close()
+	dec := json.NewDecoder(stream)
+	for {
+		var msg jsonmessage.JSONMessage
+		err := dec.Decode(&msg)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Error != nil {
+			return fmt.Errorf("pull of %s failed: %w", ref, msg.Error)
+		}
+		if progress != nil {
+			progress(msg)
+		}
+	}
+	inspect, err := Docker.ImageInspect(ctx, ref)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	log.Println("Pulled image", ref, inspect.ID)
+	return db.SaveImage(&db.Image{Ref: ref, ImageID: inspect.ID, Source: db.ImageSourcePull})
+}
+
+// presentFor checks if image ref is present locally for service's platform,
+// so image pulled for another platform is pulled again.
+func presentFor(ctx context.Context, svc *db.Service, ref string) (bool, error) {
+	present, err := imagePresent(ctx, ref)
+	if err != nil || !present || svc.Platform == nil {
+		return present, err
+	}
+	return localPlatformMatches(ctx, ref, *svc.Platform)
+}
+
+// imagePresent checks if image ref is present locally. Refs with digest
+// are matched by repo digest, others by tag, both normalized, so e.g.
+// nginx matches docker.io/library/nginx:latest. Image IDs are matched too.
+func imagePresent(ctx context.Context, ref string) (bool, error) {
+	images, err := Docker.ImageList(ctx, image.ListOptions{All: true})
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+	if strings.HasPrefix(ref, "sha256:") {
+		return slices.ContainsFunc(images, func(img image.Summary) bool { return img.ID == ref }), nil
+	}
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return false, err
+	}
+	var want string
+	var refs func(img image.Summary) []string
+	if digested, ok := named.(reference.Digested); ok {
+		want = named.Name() + "@" + digested.Digest().String()
+		refs = func(img image.Summary) []string { return img.RepoDigests }
+	} else {
+		want = reference.TagNameOnly(named).String()
+		refs = func(img image.Summary) []string { return img.RepoTags }
+	}
+	for _, img := range images {
+		for _, r := range refs(img) {
+			n, err := reference.ParseNormalizedNamed(r)
+			if err != nil {
+				continue
+			}
+			if n.String() == want {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// ImagesByID returns local images, by their ID.
+func ImagesByID(ctx context.Context) (map[string]image.Summary, error) {
+	images, err := Docker.ImageList(ctx, image.ListOptions{All: true})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	byID := map[string]image.Summary{}
+	for _, img := range images {
+		byID[img.ID] = img
+	}
+	return byID, nil
+}
+
+// ImagesInUse returns IDs of images any container was created from,
+// stopped ones and ones not managed by plasma included.
+func ImagesInUse(ctx context.Context) (map[string]bool, error) {
+	ctrs, err := Docker.ContainerList(ctx, container.ListOptions{All: true})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	inUse := map[string]bool{}
+	for _, ctr := range ctrs {
+		inUse[ctr.ImageID] = true
+	}
+	return inUse, nil
+}
+
+// RegistryDigest asks registry for current digest of image ref, using
+// project's credentials. It's returned as normalized ref with digest,
+// e.g. docker.io/library/nginx@sha256:...
+func RegistryDigest(ctx context.Context, ref string, projID uint) (string, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", err
+	}
+	auth, err := registryAuth(ref, projID)
+	if err != nil {
+		log.Println(err)
+		return "", err
+	}
+	inspect, err := Docker.DistributionInspect(ctx, ref, auth)
+	if err != nil {
+		return "", err
+	}
+	return named.Name() + "@" + inspect.Descriptor.Digest.String(), nil
+}
+
+// PinDigest returns digest service's image ref should be pinned to: the one
+// registry has for it now, or the one local image was pulled with if registry
+// can't be asked. Empty string means image is not pinned, because plasma
+// built it or it was pushed, or service pulls by policy, or with pull_policy
+// never it was not pulled at all.
+func PinDigest(ctx context.Context, ref string, pullPolicy string, projID uint) (string, error) {
+	img, err := db.GetImage(ref)
+	if err != nil {
+		log.Println(err)
+		return "", err
+	}
+	if (img != nil && img.Source != db.ImageSourcePull) || !db.Pinnable(pullPolicy) {
+		return "", nil
+	}
+	if pullPolicy == types.PullPolicyNever {
+		return RepoDigest(ctx, ref)
+	}
+	digest, err := RegistryDigest(ctx, ref, projID)
+	if err == nil {
+		return digest, nil
+	}
+	log.Println("Can't get digest of", ref, "from registry:", err)
+	local, localErr := RepoDigest(ctx, ref)
+	if localErr != nil || local == "" {
+		return "", err
+	}
+	return local, nil
+}
+
+// IsDigestOf reports if digest is a repo digest of image ref's repository,
+// so ref can be pinned to it.
+func IsDigestOf(digest string, ref string) bool {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return false
+	}
+	pinned, err := reference.ParseNormalizedNamed(digest)
+	if err != nil {
+		return false
+	}
+	_, ok := pinned.(reference.Digested)
+	return ok && pinned.Name() == named.Name()
+}
+
+// RepoDigest returns normalized repo digest local image ref was pulled with,
+// or empty string if image is missing or has none for its repository.
+func RepoDigest(ctx context.Context, ref string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", err
+	}
+	img, err := Docker.ImageInspect(ctx, ref)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	for _, d := range img.RepoDigests {
+		n, err := reference.ParseNormalizedNamed(d)
+		if err != nil {
+			continue
+		}
+		if n.Name() == named.Name() {
+			return n.String(), nil
+		}
+	}
+	return "", nil
+}
+
+// RegistryHost normalizes registry address given to 'plasma registry login',
+// e.g. https://index.docker.io/v1/ to docker.io, to match hosts of image refs.
+func RegistryHost(addr string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(addr, "https://"), "http://")
+	host, _, _ = strings.Cut(host, "/")
+	switch host {
+	case "index.docker.io", "registry-1.docker.io":
+		return "docker.io"
+	}
+	return host
+}
+
+// imageRegistry returns host of registry image ref points to,
+// docker.io for images without one.
+func imageRegistry(ref string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", err
+	}
+	return reference.Domain(named), nil
+}
+
+// registryAuth returns encoded credentials for pulling image
+// of the project, or empty string if there are none.
+func registryAuth(ref string, projID uint) (string, error) {
+	host, err := imageRegistry(ref)
+	if err != nil {
+		return "", err
+	}
+	username, password, err := db.RegistryLogin(host, projID)
+	if err != nil || username == "" {
+		return "", err
+	}
+	return registry.EncodeAuthConfig(registry.AuthConfig{
+		Username:      username,
+		Password:      password,
+		ServerAddress: serverAddress(host),
+	})
+}
+
+// serverAddress returns address docker expects for registry host.
+func serverAddress(host string) string {
+	if host == "docker.io" {
+		return "https://index.docker.io/v1/"
+	}
+	return host
+}
+
+// RegistryLogin checks credentials against registry host.
+func RegistryLogin(ctx context.Context, host string, username string, password string) error {
+	_, err := Docker.RegistryLogin(ctx, registry.AuthConfig{
+		Username:      username,
+		Password:      password,
+		ServerAddress: serverAddress(host),
+	})
+	return err
+}
+
+// LoadedImage is image loaded from 'docker save' output.
+type LoadedImage struct {
+	Ref     string   `json:"ref"` // empty for images saved by ID
+	ID      string   `json:"id"`
+	Digests []string `json:"digests"` // repo digests, if image kept them
+}
+
+// LoadImage loads images from 'docker save' output and records them
+// as pushed, so they are never pulled.
+func LoadImage(ctx context.Context, input io.Reader) ([]LoadedImage, error) {
+	resp, err := Docker.ImageLoad(ctx, input)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	loaded := []LoadedImage{}
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg jsonmessage.JSONMessage
+		err := dec.Decode(&msg)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if msg.Error != nil {
+			return nil, msg.Error
+		}
+		line := strings.TrimSpace(msg.Stream)
+		if ref, ok := strings.CutPrefix(line, "Loaded image: "); ok {
+			loaded = append(loaded, LoadedImage{Ref: ref})
+		} else if id, ok := strings.CutPrefix(line, "Loaded image ID: "); ok {
+			loaded = append(loaded, LoadedImage{ID: id})
+		}
+	}
+	for i, img := range loaded {
+		ref := img.Ref
+		if ref == "" {
+			ref = img.ID
+		}
+		inspect, err := Docker.ImageInspect(ctx, ref)
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		loaded[i].ID = inspect.ID
+		loaded[i].Digests = inspect.RepoDigests
+		if img.Ref == "" {
+			continue
+		}
+		err = db.SaveImage(&db.Image{Ref: img.Ref, ImageID: inspect.ID, Source: db.ImageSourcePush})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return loaded, nil
+}
@@ -0,0 +1,462 @@
+package container
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// Streams of LogEntry.
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+var ErrContainerNotFound = errors.New("container not found")
+
+// LogEntry is one line of container's logs.
+type LogEntry struct {
+	Time      time.Time
+	Stream    string // containers with TTY have only stdout
+	Container string
+	Service   string // empty for containers plasma does not manage
+	Line      string // without trailing newline
+}
+
+type LogResult struct {
+	Entry LogEntry
+	Err   error
+}
+
+// LogOptions selects which logs GoLogs streams.
+type LogOptions struct {
+	Follow bool   // keep streaming new lines
+	Tail   string // number of lines from the end, all if empty
+	Since  string // timestamp or duration relative to now, e.g. 10m
+	Until  string
+	Stdout bool // both streams are shown if none is set
+	Stderr bool
+}
+
+// GoLogs sends logs of container ctrName to c line by line, demultiplexed
+// into stdout and stderr, and closes c when they end, after an error
+// or when ctx is done, e.g. because client disconnected.
+func GoLogs(ctx context.Context, ctrName string, opts LogOptions, c chan LogResult) {
+	defer close(c)
+	ctr, err := Get(ctx, ctrName)
+	if err != nil {
+		send(ctx, c, LogResult{Err: err})
+		return
+	}
+	if ctr == nil {
+		send(ctx, c, LogResult{Err: fmt.Errorf("%w: %s", ErrContainerNotFound, ctrName)})
+		return
+	}
+	err = containerLogs(ctx, ctr, opts, c)
+	if err != nil && ctx.Err() == nil {
+		send(ctx, c, LogResult{Err: err})
+	}
+}
+
+// send sends result to c, unless ctx is done first, so senders
+// don't block forever when nobody reads c anymore.
+func send(ctx context.Context, c chan LogResult, result LogResult) bool {
+	select {
+	case c <- result:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// containerLogs sends lines of container's logs to c, without closing it.
+func containerLogs(ctx context.Context, ctr *container.InspectResponse, opts LogOptions, c chan LogResult) error {
+	showAll := !opts.Stdout && !opts.Stderr
+	rc, err := Docker.ContainerLogs(
+		ctx,
+		ctr.ID,
+		container.LogsOptions{
+			ShowStdout: opts.Stdout || showAll,
+			ShowStderr: opts.Stderr || showAll,
+			Follow:     opts.Follow,
+			Tail:       opts.Tail,
+			Since:      opts.Since,
+			Until:      opts.Until,
+			// parsed into LogEntry.Time
+			Timestamps: true,
+		},
+	)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	defer rc.Close()
+	// followed logs never end on their own, reader is closed to stop them
+	stop := context.AfterFunc(ctx, func() { rc.Close() })
+	defer stop()
+	entry := LogEntry{Container: strings.TrimPrefix(ctr.Name, "/")}
+	if ctr.Config != nil {
+		entry.Service = ctr.Config.Labels[LabelService]
+	}
+	if ctr.Config != nil && ctr.Config.Tty {
+		// output of TTY is not multiplexed
+		err = sendLines(ctx, rc, entry, StreamStdout, c)
+	} else {
+		err = demux(ctx, rc, entry, c)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		log.Println(err)
+	}
+	return err
+}
+
+// GoProjectLogs sends logs of all containers of project's services, or only
+// of given services, to c and closes c when they end, after an error or
+// when ctx is done. Lines logged so far are sent ordered by time.
+// With opts.Follow new lines are sent as they come, also from containers
+// created or started later, e.g. when services are updated.
+func GoProjectLogs(ctx context.Context, projName string, services []string, opts LogOptions, c chan LogResult) {
+	defer close(c)
+	ctrs, err := projectContainers(ctx, projName, services, true)
+	if err != nil {
+		send(ctx, c, LogResult{Err: err})
+		return
+	}
+	// lines after until never come
+	follow := opts.Follow && opts.Until == ""
+	cutoff := time.Now()
+	past := opts
+	past.Follow = false
+	if follow {
+		past.Until = logTime(cutoff)
+	}
+	err = collectLogs(ctx, ctrs, past, c)
+	if err != nil {
+		if ctx.Err() == nil {
+			send(ctx, c, LogResult{Err: err})
+		}
+		return
+	}
+	if !follow {
+		return
+	}
+	since := map[string]string{}
+	for _, ctr := range ctrs {
+		since[ctr.ID] = logTime(cutoff)
+	}
+	followLogs(ctx, projName, services, opts, since, c)
+}
+
+// projectContainers lists containers of project's services, or only of
+// given ones, stopped ones too if all is set.
+func projectContainers(ctx context.Context, projName string, services []string, all bool) ([]container.Summary, error) {
+	ctrs, err := Docker.ContainerList(ctx, container.ListOptions{
+		All:     all,
+		Filters: filters.NewArgs(filters.Arg("label", LabelProject+"="+projName)),
+	})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	if len(services) == 0 {
+		return ctrs, nil
+	}
+	return slices.DeleteFunc(ctrs, func(ctr container.Summary) bool {
+		return !slices.Contains(services, ctr.Labels[LabelService])
+	}), nil
+}
+
+// collectLogs reads logs of containers and sends their lines to c ordered
+// by time, merging lines of every container as they are read, as logs of
+// one container come from docker already ordered.
+func collectLogs(ctx context.Context, ctrs []container.Summary, opts LogOptions, c chan LogResult) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	chans := make([]chan LogResult, len(ctrs))
+	errs := make([]error, len(ctrs))
+	for i, ctr := range ctrs {
+		chans[i] = make(chan LogResult, 64)
+		go func() {
+			defer close(chans[i])
+			inspect, err := Docker.ContainerInspect(ctx, ctr.ID)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			errs[i] = containerLogs(ctx, &inspect, opts, chans[i])
+		}()
+	}
+	// next line of every container, nil once its logs end
+	heads := make([]*LogEntry, len(ctrs))
+	next := func(i int) {
+		heads[i] = nil
+		result, ok := <-chans[i]
+		if ok {
+			heads[i] = &result.Entry
+		}
+	}
+	for i := range chans {
+		next(i)
+	}
+	for {
+		oldest := -1
+		for i, head := range heads {
+			if head != nil && (oldest == -1 || head.Time.Before(heads[oldest].Time)) {
+				oldest = i
+			}
+		}
+		if oldest == -1 {
+			break
+		}
+		if !send(ctx, c, LogResult{Entry: *heads[oldest]}) {
+			return ctx.Err()
+		}
+		next(oldest)
+	}
+	// channels are closed, so errors are set
+	return errors.Join(errs...)
+}
+
+// followLogs follows logs of running containers of the project, checking
+// every second for containers which are not followed yet. Containers are
+// followed since times given by their ID, from their start if there is none.
+// Container whose stream broke while it runs is followed again right after
+// the last line sent from it, so no lines are lost.
+func followLogs(
+	ctx context.Context,
+	projName string,
+	services []string,
+	opts LogOptions,
+	since map[string]string,
+	c chan LogResult,
+) {
+	var mu sync.Mutex
+	following := map[string]bool{}
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		ctrs, err := projectContainers(ctx, projName, services, false)
+		if err != nil {
+			log.Println(err)
+		}
+		for _, ctr := range ctrs {
+			mu.Lock()
+			if following[ctr.ID] {
+				mu.Unlock()
+				continue
+			}
+			following[ctr.ID] = true
+			live := opts
+			live.Tail = ""
+			live.Since = since[ctr.ID]
+			mu.Unlock()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				last, err := followContainer(ctx, ctr.ID, live, c)
+				if err != nil && ctx.Err() == nil {
+					log.Println(err)
+				}
+				inspect, inspectErr := Docker.ContainerInspect(ctx, ctr.ID)
+				running := inspectErr == nil && inspect.State != nil && inspect.State.Running
+				mu.Lock()
+				defer mu.Unlock()
+				following[ctr.ID] = false
+				switch {
+				case !running:
+					// stream ends when container stops, it's followed again if it starts
+					since[ctr.ID] = logTime(time.Now())
+				case !last.IsZero():
+					// since includes lines logged at that time
+					since[ctr.ID] = logTime(last.Add(time.Nanosecond))
+				}
+			}()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// followContainer sends logs of container to c until its stream ends,
+// returning time of the last line it sent.
+func followContainer(ctx context.Context, ctrID string, opts LogOptions, c chan LogResult) (time.Time, error) {
+	inspect, err := Docker.ContainerInspect(ctx, ctrID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	lines := make(chan LogResult)
+	var last time.Time
+	forwarded := make(chan struct{})
+	go func() {
+		defer close(forwarded)
+		for result := range lines {
+			if send(ctx, c, result) {
+				last = result.Entry.Time
+			}
+		}
+	}()
+	err = containerLogs(ctx, &inspect, opts, lines)
+	close(lines)
+	<-forwarded
+	return last, err
+}
+
+// logTime formats t as since or until option of docker logs.
+func logTime(t time.Time) string {
+	return fmt.Sprintf("%d.%09d", t.Unix(), t.Nanosecond())
+}
+
+// demux splits docker's multiplexed log stream into stdout and stderr
+// and sends lines of both in order they were logged. Frames are read here
+// instead of with stdcopy.StdCopy, which writes streams to separate writers
+// and so loses order of lines between them. Lines can be split across many
+// frames, as docker splits long lines into partial messages.
+func demux(ctx context.Context, rc io.Reader, entry LogEntry, c chan LogResult) error {
+	br := bufio.NewReader(rc)
+	header := make([]byte, 8)
+	partial := map[string]*partialLine{}
+	for _, stream := range []string{StreamStdout, StreamStderr} {
+		partial[stream] = &partialLine{entry: entry}
+		partial[stream].entry.Stream = stream
+	}
+	for {
+		_, err := io.ReadFull(br, header)
+		if errors.Is(err, io.EOF) {
+			// last lines without trailing newline
+			for _, stream := range []string{StreamStdout, StreamStderr} {
+				if p := partial[stream]; p.started && !send(ctx, c, LogResult{Entry: p.finish()}) {
+					return ctx.Err()
+				}
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		payload := make([]byte, binary.BigEndian.Uint32(header[4:]))
+		_, err = io.ReadFull(br, payload)
+		if err != nil {
+			return err
+		}
+		var stream string
+		switch stdcopy.StdType(header[0]) {
+		case stdcopy.Stdout:
+			stream = StreamStdout
+		case stdcopy.Stderr:
+			stream = StreamStderr
+		case stdcopy.Systemerr:
+			return fmt.Errorf("error from docker: %s", payload)
+		default:
+			return fmt.Errorf("unknown stream %v in docker logs", header[0])
+		}
+		for _, line := range partial[stream].add(string(payload)) {
+			if !send(ctx, c, LogResult{Entry: line}) {
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// partialLine collects line of one stream from docker's messages,
+// each of them starting with its own timestamp.
+type partialLine struct {
+	entry   LogEntry
+	started bool
+}
+
+// add adds message from frame's payload and returns lines it completed,
+// with time of their first message.
+func (p *partialLine) add(payload string) []LogEntry {
+	var lines []LogEntry
+	for payload != "" {
+		t, rest, ok := cutTimestamp(payload)
+		if ok {
+			payload = rest
+			if !p.started {
+				p.entry.Time = t
+			}
+		}
+		p.started = true
+		text, rest, found := strings.Cut(payload, "\n")
+		p.entry.Line += text
+		if !found {
+			break
+		}
+		lines = append(lines, p.finish())
+		payload = rest
+	}
+	return lines
+}
+
+// finish returns collected line and starts a new one.
+func (p *partialLine) finish() LogEntry {
+	line := p.entry
+	line.Line = strings.TrimRight(line.Line, "\r")
+	p.entry.Line = ""
+	p.entry.Time = time.Time{}
+	p.started = false
+	return line
+}
+
+// sendLines sends every line read from r as entry of stream,
+// until r ends or ctx is done.
+func sendLines(ctx context.Context, r io.Reader, entry LogEntry, stream string, c chan LogResult) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" && !send(ctx, c, LogResult{Entry: parseLogLine(entry, stream, line)}) {
+			return ctx.Err()
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// parseLogLine fills entry with line of stream, cutting off
+// timestamp docker put in front of it.
+func parseLogLine(entry LogEntry, stream string, line string) LogEntry {
+	entry.Stream = stream
+	// TTY lines end with \r\n
+	line = strings.TrimRight(line, "\r\n")
+	t, rest, ok := cutTimestamp(line)
+	if ok {
+		entry.Time = t
+		line = rest
+	}
+	entry.Line = line
+	return entry
+}
+
+// cutTimestamp cuts off timestamp docker puts in front of every message.
+func cutTimestamp(msg string) (time.Time, string, bool) {
+	ts, rest, _ := strings.Cut(msg, " ")
+	t, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return time.Time{}, msg, false
+	}
+	return t, rest, true
+}
@@ -0,0 +1,70 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+func TestDemux(t *testing.T) {
+	var b bytes.Buffer
+	stdout := stdcopy.NewStdWriter(&b, stdcopy.Stdout)
+	stderr := stdcopy.NewStdWriter(&b, stdcopy.Stderr)
+	// every write is one frame, as docker sends them
+	stdout.Write([]byte("2024-01-01T00:00:01.000000001Z first\n"))
+	// long line split into partial messages with their own timestamps
+	stdout.Write([]byte("2024-01-01T00:00:02Z long "))
+	stderr.Write([]byte("2024-01-01T00:00:03Z error\r\n"))
+	stdout.Write([]byte("2024-01-01T00:00:04Z line\n"))
+	stdout.Write([]byte("2024-01-01T00:00:05Z a\n2024-01-01T00:00:06Z b\n"))
+	stdout.Write([]byte("2024-01-01T00:00:07Z no newline"))
+
+	c := make(chan LogResult, 10)
+	err := demux(context.Background(), &b, LogEntry{Container: "p_svc", Service: "p_svc"}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(c)
+	want := []struct {
+		second int
+		stream string
+		line   string
+	}{
+		{1, StreamStdout, "first"},
+		{3, StreamStderr, "error"},
+		{2, StreamStdout, "long line"},
+		{5, StreamStdout, "a"},
+		{6, StreamStdout, "b"},
+		{7, StreamStdout, "no newline"},
+	}
+	var got []LogEntry
+	for result := range c {
+		got = append(got, result.Entry)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v lines, want %v: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		g := got[i]
+		if g.Line != w.line || g.Stream != w.stream || g.Time.Unix() != time.Date(2024, 1, 1, 0, 0, w.second, 0, time.UTC).Unix() {
+			t.Errorf("line %v = %+v, want %q on %s at second %v", i, g, w.line, w.stream, w.second)
+		}
+		if g.Container != "p_svc" || g.Service != "p_svc" {
+			t.Errorf("line %v has container %q and service %q", i, g.Container, g.Service)
+		}
+	}
+	if got[0].Time.Nanosecond() != 1 {
+		t.Errorf("time of first line lost nanoseconds: %v", got[0].Time)
+	}
+}
+
+func TestDemuxUnknownStream(t *testing.T) {
+	frame := []byte{7, 0, 0, 0, 0, 0, 0, 1, 'x'}
+	err := demux(context.Background(), bytes.NewReader(frame), LogEntry{}, make(chan LogResult, 1))
+	if err == nil {
+		t.Error("demux of unknown stream did not fail")
+	}
+}
@@ -0,0 +1,204 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/compose-spec/compose-go/v2/types"
+	"github.com/containerd/errdefs"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pgulb/plasma/db"
+)
+
+// ParsePlatform parses platform like linux/amd64 or linux/arm/v7,
+// as in service's platform field.
+func ParsePlatform(s string) (ocispec.Platform, error) {
+	parts := strings.Split(strings.ToLower(s), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return ocispec.Platform{}, fmt.Errorf("platform '%s' is not like 'linux/amd64' or 'linux/arm/v7'", s)
+	}
+	p := ocispec.Platform{OS: parts[0], Architecture: normalizeArch(parts[1])}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+// normalizeArch turns names uname uses into ones images use.
+func normalizeArch(arch string) string {
+	switch arch {
+	case "x86_64", "x86-64":
+		return "amd64"
+	case "aarch64":
+		return "arm64"
+	}
+	return arch
+}
+
+func platformString(p ocispec.Platform) string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// platformMatches reports if image built for p runs on want.
+// Variant is only compared if want has one, v8 is default for arm64.
+func platformMatches(want ocispec.Platform, p ocispec.Platform) bool {
+	if want.OS != p.OS || want.Architecture != normalizeArch(p.Architecture) {
+		return false
+	}
+	if want.Variant == "" {
+		return true
+	}
+	variant := p.Variant
+	if variant == "" && p.Architecture == "arm64" {
+		variant = "v8"
+	}
+	return want.Variant == variant
+}
+
+// ServerPlatform returns platform of docker plasma runs containers on.
+func ServerPlatform(ctx context.Context) (ocispec.Platform, error) {
+	ver, err := Docker.ServerVersion(ctx)
+	if err != nil {
+		log.Println(err)
+		return ocispec.Platform{}, err
+	}
+	return ocispec.Platform{OS: ver.Os, Architecture: normalizeArch(ver.Arch)}, nil
+}
+
+// imagePlatforms returns platforms image ref is available for, from
+// registry for pulled images, or of local image for pushed and built ones
+// or if registry can't be asked. Empty means they are not known.
+func imagePlatforms(ctx context.Context, ref string, projID uint) ([]ocispec.Platform, error) {
+	img, err := db.GetImage(ref)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	if img == nil || img.Source == db.ImageSourcePull {
+		auth, err := registryAuth(ref, projID)
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		inspect, err := Docker.DistributionInspect(ctx, ref, auth)
+		if err == nil {
+			return inspect.Platforms, nil
+		}
+		log.Println("Can't get platforms of", ref, "from registry:", err)
+	}
+	local, err := Docker.ImageInspect(ctx, ref)
+	if errdefs.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return []ocispec.Platform{{OS: local.Os, Architecture: local.Architecture, Variant: local.Variant}}, nil
+}
+
+// localPlatformMatches reports if local image ref is built for platform,
+// e.g. it could be pulled earlier for another one.
+func localPlatformMatches(ctx context.Context, ref string, platform string) (bool, error) {
+	want, err := ParsePlatform(platform)
+	if err != nil {
+		return false, err
+	}
+	local, err := Docker.ImageInspect(ctx, ref)
+	if err != nil {
+		return false, err
+	}
+	return platformMatches(want, ocispec.Platform{
+		OS:           local.Os,
+		Architecture: local.Architecture,
+		Variant:      local.Variant,
+	}), nil
+}
+
+// ValidatePlatforms checks that images of project's services, or digests
+// they are pinned to, are available for service's platform, or server's
+// platform if service has none, so they don't crash with exec format error.
+// Images whose platforms can't be known are not checked, neither are any
+// if docker can't be reached, which is reported as a warning.
+func ValidatePlatforms(ctx context.Context, project *types.Project, digests map[string]string) ([]Issue, error) {
+	var projID uint
+	proj, err := db.GetProject(project.Name)
+	if err == nil {
+		projID = proj.ID
+	}
+	issues := CheckPlatforms(project)
+	server, err := ServerPlatform(ctx)
+	if err != nil {
+		issues = append(issues, warning("", "platform", "not checked, can't get docker's platform: "+err.Error()))
+		return issues, checkIssues(issues, false)
+	}
+	for _, name := range project.ServiceNames() {
+		svc := project.Services[name]
+		if svc.Image == "" {
+			continue
+		}
+		want := server
+		if svc.Platform != "" {
+			want, err = ParsePlatform(svc.Platform)
+			if err != nil {
+				// reported by CheckPlatforms
+				continue
+			}
+		}
+		ref := svc.Image
+		if digest, ok := digests[svc.Image]; ok {
+			ref = digest
+		}
+		platforms, err := imagePlatforms(ctx, ref, projID)
+		if err != nil {
+			log.Println("Can't check platforms of", ref, err)
+			continue
+		}
+		if len(platforms) == 0 || slices.ContainsFunc(platforms, func(p ocispec.Platform) bool {
+			return platformMatches(want, p)
+		}) {
+			continue
+		}
+		offered := []string{}
+		for _, p := range platforms {
+			// attestation manifests have unknown platform
+			if p.OS != "unknown" {
+				offered = append(offered, platformString(p))
+			}
+		}
+		issues = append(issues, Issue{
+			Severity: SeverityError,
+			Service:  name,
+			Key:      "platform",
+			Message: fmt.Sprintf(
+				"image %s is not available for %s, only for %s",
+				svc.Image, platformString(want), strings.Join(offered, ", "),
+			),
+		})
+	}
+	return issues, checkIssues(issues, false)
+}
+
+// CheckPlatforms checks that platforms of project's services are valid,
+// without asking docker if their images are available for them.
+func CheckPlatforms(project *types.Project) []Issue {
+	issues := []Issue{}
+	for _, name := range project.ServiceNames() {
+		svc := project.Services[name]
+		if svc.Platform == "" {
+			continue
+		}
+		_, err := ParsePlatform(svc.Platform)
+		if err != nil {
+			issues = append(issues, Issue{Severity: SeverityError, Service: name, Key: "platform", Message: err.Error()})
+		}
+	}
+	return issues
+}
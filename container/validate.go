@@ -0,0 +1,239 @@
+package container
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// Severities of compose issues. Warnings are about features plasma ignores,
+// errors about services it can't run at all.
+const (
+	SeverityWarning = "warning"
+	SeverityError   = "error"
+)
+
+var ErrInvalidCompose = errors.New("compose file is not supported by plasma")
+
+// Issue is a compose feature plasma does not support.
+type Issue struct {
+	Severity string `json:"severity"`
+	Service  string `json:"service,omitempty"` // empty for top-level keys
+	Key      string `json:"key"`
+	Message  string `json:"message"`
+}
+
+func (i Issue) String() string {
+	if i.Service == "" {
+		return fmt.Sprintf("%s: %s: %s", i.Severity, i.Key, i.Message)
+	}
+	return fmt.Sprintf("%s: service %s: %s: %s", i.Severity, i.Service, i.Key, i.Message)
+}
+
+func warning(svc string, key string, msg string) Issue {
+	return Issue{Severity: SeverityWarning, Service: svc, Key: key, Message: msg}
+}
+
+// service keys plasma uses for running service
+var supportedServiceKeys = []string{
+	"build",
+	"command",
+	"deploy",
+	"entrypoint",
+	"env_file", // already merged into environment by compose loader
+	"environment",
+	"expose",
+	"healthcheck",
+	"hostname",
+	"image",
+	"networks",
+	"platform",
+	"ports",
+	"profiles", // inactive ones are reported separately
+	"pull_policy",
+	"scale",
+	"volumes",
+}
+
+// deploy keys plasma uses for running service
+var supportedDeployKeys = []string{
+	"replicas",
+	"update_config",
+}
+
+// deploy.update_config keys plasma uses for service's update strategy
+var supportedUpdateConfigKeys = []string{
+	"monitor",
+	"order",
+}
+
+// build keys plasma passes to docker when building service's image
+var supportedBuildKeys = []string{
+	"args",
+	"context",
+	"dockerfile",
+	"labels",
+	"no_cache",
+	"pull",
+	"tags",
+	"target",
+}
+
+// Validate returns compose features of the project plasma ignores,
+// and services it can't run.
+func Validate(project *types.Project) ([]Issue, error) {
+	issues := []Issue{}
+	for _, name := range project.ServiceNames() {
+		svc := project.Services[name]
+		if svc.Build != nil {
+			buildIssues, err := validateBuild(name, svc.Build)
+			if err != nil {
+				return nil, err
+			}
+			issues = append(issues, buildIssues...)
+		}
+		keys, err := setKeys(svc)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if !slices.Contains(supportedServiceKeys, key) {
+				issues = append(issues, warning(name, key, "not supported, ignored"))
+			}
+		}
+		if svc.Deploy != nil {
+			keys, err := setKeys(svc.Deploy)
+			if err != nil {
+				return nil, err
+			}
+			for _, key := range keys {
+				if !slices.Contains(supportedDeployKeys, key) {
+					issues = append(issues, warning(name, "deploy."+key, "not supported, ignored"))
+				}
+			}
+			if svc.Deploy.UpdateConfig != nil {
+				keys, err := setKeys(svc.Deploy.UpdateConfig)
+				if err != nil {
+					return nil, err
+				}
+				for _, key := range keys {
+					if !slices.Contains(supportedUpdateConfigKeys, key) {
+						issues = append(issues, warning(name, "deploy.update_config."+key, "not supported, ignored"))
+					}
+				}
+			}
+		}
+		if slices.ContainsFunc(slices.Collect(maps.Keys(svc.Networks)), func(n string) bool { return n != "default" }) {
+			issues = append(issues, warning(
+				name, "networks", "services only join project's network "+NetworkName(project.Name),
+			))
+		}
+		for _, port := range svc.Ports {
+			if port.Protocol != "" && port.Protocol != "tcp" {
+				issues = append(issues, warning(
+					name, "ports", fmt.Sprintf("port %v is published as tcp, not %s", port.Target, port.Protocol),
+				))
+			}
+		}
+		for _, vol := range svc.Volumes {
+			if vol.Type != types.VolumeTypeVolume && vol.Type != types.VolumeTypeBind {
+				issues = append(issues, warning(
+					name, "volumes", fmt.Sprintf("%s mount at %s is not supported, ignored", vol.Type, vol.Target),
+				))
+			}
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(project.Networks)) {
+		if name != "default" {
+			issues = append(issues, warning("", "networks."+name, "not supported, ignored"))
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(project.Secrets)) {
+		issues = append(issues, warning("", "secrets."+name, "not supported, ignored"))
+	}
+	for _, name := range slices.Sorted(maps.Keys(project.Configs)) {
+		issues = append(issues, warning("", "configs."+name, "not supported, ignored"))
+	}
+	for _, name := range slices.Sorted(maps.Keys(project.Models)) {
+		issues = append(issues, warning("", "models."+name, "not supported, ignored"))
+	}
+	for _, name := range slices.Sorted(maps.Keys(project.DisabledServices)) {
+		issues = append(issues, warning(name, "profiles", "profile is not active, service ignored"))
+	}
+	return issues, nil
+}
+
+// validateBuild checks that service's image can be built from
+// build context uploaded by CLI.
+func validateBuild(svcName string, b *types.BuildConfig) ([]Issue, error) {
+	issues := []Issue{}
+	if strings.Contains(b.Context, "://") || strings.HasPrefix(b.Context, "git@") {
+		issues = append(issues, Issue{
+			Severity: SeverityError,
+			Service:  svcName,
+			Key:      "build.context",
+			Message:  "remote build contexts are not supported, use local directory",
+		})
+	}
+	if b.DockerfileInline != "" {
+		issues = append(issues, Issue{
+			Severity: SeverityError,
+			Service:  svcName,
+			Key:      "build.dockerfile_inline",
+			Message:  "not supported, put dockerfile in build context",
+		})
+	}
+	keys, err := setKeys(b)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if key != "dockerfile_inline" && !slices.Contains(supportedBuildKeys, key) {
+			issues = append(issues, warning(svcName, "build."+key, "not supported, ignored"))
+		}
+	}
+	return issues, nil
+}
+
+// checkIssues returns ErrInvalidCompose if there are errors among issues,
+// or any issues at all in strict mode.
+func checkIssues(issues []Issue, strict bool) error {
+	n := 0
+	for _, issue := range issues {
+		if strict || issue.Severity == SeverityError {
+			n++
+		}
+	}
+	if n > 0 {
+		return fmt.Errorf("%w, %v issues found", ErrInvalidCompose, n)
+	}
+	return nil
+}
+
+// setKeys returns sorted json keys of v which have non-empty values.
+func setKeys(v any) ([]string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]json.RawMessage
+	err = json.Unmarshal(b, &raw)
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	for k, v := range raw {
+		switch string(v) {
+		case "null", "{}", "[]", `""`, "false", "0":
+			continue
+		}
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys, nil
+}
@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/pgulb/plasma/container"
+	"github.com/pgulb/plasma/db"
+)
+
+// autoscale samples stats of service's running replicas and changes
+// their count to get average usage close to targets from x-plasma-autoscale.
+func autoscale(svc *db.Service) {
+	var cfg db.AutoscaleInDB
+	err := json.Unmarshal([]byte(*svc.Autoscale), &cfg)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), opTimeout)
+	defer cancel()
+	ctrs, err := container.ListService(ctx, svc.Name)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	var cpuSum, memSum float64
+	sampled := 0
+	for _, ctr := range ctrs {
+		if ctr.State != "running" {
+			continue
+		}
+		cpu, mem, err := container.Stats(ctx, ctr.ID)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		cpuSum += cpu
+		memSum += mem
+		sampled++
+	}
+	if sampled == 0 {
+		log.Println("No running replicas of", svc.Name, "to sample stats from.")
+		return
+	}
+	cpu := cpuSum / float64(sampled)
+	mem := memSum / float64(sampled)
+	current := float64(svc.Replicas)
+	desired := 0.0
+	if cfg.CPU > 0 {
+		desired = max(desired, math.Ceil(current*cpu/cfg.CPU))
+	}
+	if cfg.Memory > 0 {
+		desired = max(desired, math.Ceil(current*mem/cfg.Memory))
+	}
+	replicas := min(max(uint(desired), cfg.Min), cfg.Max)
+	if replicas == svc.Replicas {
+		return
+	}
+	cooldown := cfg.CooldownUp
+	if replicas < svc.Replicas {
+		cooldown = cfg.CooldownDown
+	}
+	if svc.LastScaledAt != nil && time.Since(*svc.LastScaledAt) < cooldown {
+		log.Println("Service", svc.Name, "would scale to", replicas, "replicas, but it is in cooldown.")
+		return
+	}
+	if replicas > 1 {
+		publishes, err := svc.PublishesPorts()
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if publishes {
+			log.Println("Service", svc.Name, "publishes host ports, can't scale it above 1 replica.")
+			return
+		}
+	}
+	reason := fmt.Sprintf("average cpu %.1f%% (target %.1f%%), memory %.1f%% (target %.1f%%)",
+		cpu, cfg.CPU, mem, cfg.Memory)
+	if dryRun {
+		log.Println("Dry run, would scale", svc.Name, "to", replicas, "replicas:", reason)
+		return
+	}
+	log.Println("Scaling", svc.Name, "from", svc.Replicas, "to", replicas, "replicas:", reason)
+	err = db.ScaleTo(svc, replicas, reason)
+	if err != nil {
+		log.Println(err)
+	}
+}
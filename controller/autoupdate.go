@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/pgulb/plasma/container"
+	"github.com/pgulb/plasma/db"
+)
+
+// autoUpdate checks registry for new digest of service's image, if service
+// opted in with x-plasma-auto-update, it's time to check again and it's inside
+// one of service's maintenance windows. New digest is pulled and service is
+// pinned to it, replacing containers using its update strategy. If new
+// containers don't become healthy, service goes back to the old digest.
+func autoUpdate(svc *db.Service, names []string) (string, error) {
+	if svc.AutoUpdate == nil {
+		return actionNone, nil
+	}
+	var cfg db.AutoUpdateInDB
+	err := json.Unmarshal([]byte(*svc.AutoUpdate), &cfg)
+	if err != nil {
+		return actionNone, err
+	}
+	now := time.Now()
+	if svc.LastImageCheck != nil && now.Sub(*svc.LastImageCheck) < cfg.Interval {
+		return actionNone, nil
+	}
+	if !cfg.InWindow(now) {
+		log.Println("Service", svc.Name, "is outside of its maintenance windows, not checking image.")
+		return actionNone, nil
+	}
+	img, err := db.GetImage(svc.Image)
+	if err != nil {
+		return actionNone, err
+	}
+	if img != nil && img.Source != db.ImageSourcePull {
+		log.Println("Image", svc.Image, "comes from", img.Source, "and can't be updated from registry.")
+		return actionNone, nil
+	}
+	err = db.SetLastImageCheck(svc, now)
+	if err != nil {
+		return actionNone, err
+	}
+	svc.LastImageCheck = &now
+	ctx, cancel := context.WithTimeout(context.Background(), opTimeout)
+	defer cancel()
+	newDigest, err := container.RegistryDigest(ctx, svc.Image, svc.ProjectId)
+	if err != nil {
+		return actionNone, err
+	}
+	oldPin := svc.ImageDigest
+	oldDigest := "unknown digest"
+	if oldPin != nil {
+		oldDigest = *oldPin
+	} else {
+		local, err := container.RepoDigest(ctx, svc.Image)
+		if err != nil {
+			return actionNone, err
+		}
+		if local != "" {
+			oldDigest = local
+		}
+	}
+	if newDigest == oldDigest {
+		log.Println("Image", svc.Image, "of", svc.Name, "is up to date.")
+		return actionNone, nil
+	}
+	svc.ImageDigest = &newDigest
+	hash := svc.SpecHash()
+	if svc.FailedSpecHash != nil && *svc.FailedSpecHash == hash {
+		log.Println("Update of", svc.Name, "to", newDigest, "failed before, skipping.")
+		svc.ImageDigest = oldPin
+		return actionNone, nil
+	}
+	log.Println("Image", svc.Image, "of", svc.Name, "changed from", oldDigest, "to", newDigest)
+	if dryRun {
+		log.Println("Dry run, would update it.")
+		svc.ImageDigest = oldPin
+		return actionWouldUpdate, nil
+	}
+	err = container.EnsureImage(ctx, svc, nil)
+	if err != nil {
+		svc.ImageDigest = oldPin
+		return actionNone, err
+	}
+	notUpdated := func() {
+		addEvent(svc, "auto-update", fmt.Sprintf(
+			"image %s of %s not updated from %s to %s, new containers were not healthy",
+			svc.Image, svc.Name, oldDigest, newDigest,
+		))
+	}
+	action, err := update(svc, names)
+	if errors.Is(err, errRolledBack) {
+		// update marked new digest as failed, replicas already updated
+		// to it before one failed are brought back to old one
+		svc.ImageDigest = oldPin
+		notUpdated()
+		_, rollbackErr := update(svc, names)
+		if rollbackErr != nil {
+			log.Println(rollbackErr)
+		}
+		return action, err
+	}
+	if err == nil {
+		// recreate strategy does not wait for new containers
+		err = waitUpdated(svc, names)
+		if err != nil {
+			notUpdated()
+			return rollbackDigest(svc, names, oldPin, hash, err)
+		}
+	}
+	if err != nil {
+		svc.ImageDigest = oldPin
+		return action, err
+	}
+	err = db.SetImageDigest(svc, &newDigest)
+	if err != nil {
+		return action, err
+	}
+	addEvent(svc, "auto-update", fmt.Sprintf(
+		"image %s of %s updated from %s to %s", svc.Image, svc.Name, oldDigest, newDigest,
+	))
+	return actionUpdated, nil
+}
+
+// waitUpdated waits until all service's replicas are healthy,
+// as long as service's update health timeout allows.
+func waitUpdated(svc *db.Service, names []string) error {
+	timeout := defaultUpdateTimeout
+	if svc.UpdateTimeout != nil {
+		timeout = *svc.UpdateTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, name := range names {
+		err := container.WaitHealthy(ctx, svc, name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollbackDigest replaces containers created from new digest which did not
+// become healthy with ones from oldPin, and marks new spec as failed.
+func rollbackDigest(svc *db.Service, names []string, oldPin *string, failedHash string, cause error) (string, error) {
+	newDigest := *svc.ImageDigest
+	svc.ImageDigest = oldPin
+	addEvent(svc, "rollback", fmt.Sprintf(
+		"update of %s to %s rolled back: %s", svc.Name, newDigest, cause,
+	))
+	err := db.SetFailedSpecHash(svc, &failedHash)
+	if err != nil {
+		log.Println(err)
+	}
+	svc.FailedSpecHash = &failedHash
+	_, err = update(svc, names)
+	if err != nil {
+		return actionNone, err
+	}
+	return actionRolledBack, fmt.Errorf("%w: %s", errRolledBack, cause)
+}
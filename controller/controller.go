@@ -1,104 +1,263 @@
 package controller
 
 import (
+	"context"
+	"errors"
 	"log"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/pgulb/plasma/container"
 	"github.com/pgulb/plasma/db"
 )
 
+var (
+	workers   = 4
+	opTimeout = 5 * time.Minute
+	// in dry-run mode controller only logs and records what it would do
+	dryRun = false
+)
+
 func svcLoop(services []db.Service) {
+	jobs := make(chan db.Service)
+	var wg sync.WaitGroup
+	for range min(workers, len(services)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for svc := range jobs {
+				reconcileService(svc)
+			}
+		}()
+	}
 	for _, svc := range services {
-		log.Println("-")
-		log.Printf("Checking service '%s'\n", svc.Name)
-		if svc.Image == "" {
-			log.Println("Plasma does not handle 'build' image services.")
-			log.Println("Service", svc.Name, "has no image, skipping.")
-			continue
-		}
-		ctr, err := container.Get(svc.Name)
+		jobs <- svc
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// Actions recorded in db.ReconcileStatus.
+const (
+	actionNone         = "none"
+	actionStarted      = "started"
+	actionKilled       = "killed"
+	actionSkipped      = "skipped"
+	actionStopped      = "stopped"
+	actionWouldStart   = "would start"
+	actionWouldRestart = "would restart"
+	actionWouldStop    = "would stop"
+	actionUpdated      = "updated"
+	actionRolledBack   = "rolled back"
+	actionWouldUpdate  = "would update"
+)
+
+func reconcileService(svc db.Service) {
+	log.Printf("Checking service '%s'\n", svc.Name)
+	if svc.Image == "" {
+		log.Println("Service", svc.Name, "has no image, skipping.")
+		recordStatus(&svc, svc.Name, actionSkipped, errors.New("service has no image"))
+		return
+	}
+	unlock := TryLockService(svc.Name)
+	if unlock == nil {
+		log.Println("Service", svc.Name, "is locked by another operation, skipping.")
+		recordStatus(&svc, svc.Name, actionSkipped, errors.New("service is locked by another operation"))
+		return
+	}
+	defer unlock()
+	if svc.DesiredState == db.StateStopped {
+		action, err := stop(&svc, nil)
 		if err != nil {
 			log.Println(err)
 			log.Println("Going to next service.")
+		}
+		recordStatus(&svc, svc.Name, action, err)
+		return
+	}
+	// status is recorded for the first replica that needed action,
+	// or the first one if all of them are fine
+	names := container.ReplicaNames(&svc)
+	statusName, statusAction := names[0], actionNone
+	var statusErr error
+	for _, name := range names {
+		action, err := reconcile(&svc, name)
+		if err != nil {
+			log.Println(err)
+			log.Println("Going to next replica.")
+		}
+		if statusAction == actionNone && statusErr == nil && (action != actionNone || err != nil) {
+			statusName, statusAction, statusErr = name, action, err
+		}
+	}
+	action, err := autoUpdate(&svc, names)
+	if err != nil {
+		log.Println(err)
+	}
+	if statusAction == actionNone && statusErr == nil {
+		statusAction, statusErr = action, err
+	}
+	action, err = update(&svc, names)
+	if err != nil {
+		log.Println(err)
+	}
+	if statusAction == actionNone && statusErr == nil {
+		statusAction, statusErr = action, err
+	}
+	action, err = stop(&svc, names)
+	if err != nil {
+		log.Println(err)
+	}
+	if statusAction == actionNone && statusErr == nil {
+		statusAction, statusErr = action, err
+	}
+	recordStatus(&svc, statusName, statusAction, statusErr)
+	if svc.Autoscale != nil {
+		autoscale(&svc)
+	}
+}
+
+// reconcile brings container of service's replica called name
+// to running and healthy state, returning the action it took.
+func reconcile(svc *db.Service, name string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), opTimeout)
+	ctr, err := container.Get(ctx, name)
+	cancel()
+	if err != nil {
+		return actionNone, err
+	}
+	present, alive, healthy := container.IsPresentAliveAndHealthy(svc, ctr)
+	if !present {
+		log.Println("Container", name, "is not present!")
+		if dryRun {
+			log.Println("Dry run, would run it.")
+			return actionWouldStart, nil
+		}
+		log.Println("Trying to run it...")
+		ctx, cancel := context.WithTimeout(context.Background(), opTimeout)
+		defer cancel()
+		err := container.Run(ctx, svc, name)
+		if err != nil {
+			return actionNone, err
+		}
+		log.Println("Container", name, "started, going to next.")
+		return actionStarted, nil
+	}
+	log.Println("Container", name, "is present.")
+	if !alive {
+		log.Println("Container", name, "is not running!")
+		return restart(svc, name, ctr.ID)
+	}
+	log.Println("Container", name, "is running.")
+	if container.IsStarting(ctr) {
+		log.Println("Container", name, "is starting, waiting for its healthcheck.")
+		return actionNone, nil
+	}
+	if !healthy {
+		log.Println("Container", name, "is not healthy!")
+		return restart(svc, name, ctr.ID)
+	}
+	log.Println("Container", name, "is healthy.")
+	return actionNone, nil
+}
+
+// stop stops and removes service's containers other than keep,
+// which are left over after scaling down or all of them if service
+// should be stopped.
+func stop(svc *db.Service, keep []string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), opTimeout)
+	defer cancel()
+	ctrs, err := container.ListService(ctx, svc.Name)
+	if err != nil {
+		return actionNone, err
+	}
+	action := actionNone
+	for _, ctr := range ctrs {
+		name := strings.TrimPrefix(ctr.Names[0], "/")
+		if slices.Contains(keep, name) {
 			continue
-		} else {
-			present, alive, healthy := container.IsPresentAliveAndHealthy(&svc, ctr)
-			var wasJustRan bool
-			if !present {
-				log.Println("Service", svc.Name, "is not present!")
-				log.Println("Trying to run it...")
-				err := container.Run(&svc)
-				if err != nil {
-					log.Println(err)
-					log.Println("Going to next service.")
-					continue
-				}
-				wasJustRan = true
-			} else {
-				log.Println("Service", svc.Name, "is present.")
-			}
-			if wasJustRan {
-				log.Println("Service", svc.Name, "started, going to next.")
-				continue
-			}
-			if !alive {
-				log.Println("Service", svc.Name, "is not running!")
-				log.Println("Trying to kill it...")
-				err := container.Kill(ctr.ID)
-				if err != nil {
-					log.Println(err)
-					log.Println("Going to next service.")
-					continue
-				}
-				err = upKillCount(&svc)
-				if err != nil {
-					log.Println(err)
-					log.Println("Going to next service.")
-					continue
-				}
-				log.Println("Trying to run it...")
-				err = container.Run(&svc)
-				if err != nil {
-					log.Println(err)
-					log.Println("Going to next service.")
-					continue
-				}
-				wasJustRan = true
-			} else {
-				log.Println("Service", svc.Name, "is running.")
-			}
-			if wasJustRan {
-				log.Println("Service", svc.Name, "started, going to next.")
-				continue
-			}
-			if !healthy {
-				log.Println("Service", svc.Name, "is not healthy!")
-				log.Println("Trying to kill it...")
-				err := container.Kill(ctr.ID)
-				if err != nil {
-					log.Println(err)
-					log.Println("Going to next service.")
-					continue
-				}
-				err = upKillCount(&svc)
-				if err != nil {
-					log.Println(err)
-					log.Println("Going to next service.")
-					continue
-				}
-				log.Println("Trying to run it...")
-				err = container.Run(&svc)
-				if err != nil {
-					log.Println(err)
-					log.Println("Going to next service.")
-					continue
-				}
-			} else {
-				log.Println("Service", svc.Name, "is healthy.")
-			}
 		}
+		log.Println("Container", name, "should not be running!")
+		if dryRun {
+			log.Println("Dry run, would stop it.")
+			action = actionWouldStop
+			continue
+		}
+		log.Println("Trying to stop it...")
+		err := container.Stop(ctx, ctr.ID)
+		if err != nil {
+			return action, err
+		}
+		log.Println("Container", name, "stopped.")
+		action = actionStopped
+	}
+	return action, nil
+}
+
+// restart kills replica's container and runs it again.
+func restart(svc *db.Service, name string, ctrID string) (string, error) {
+	if dryRun {
+		log.Println("Dry run, would kill and run it again.")
+		return actionWouldRestart, nil
+	}
+	log.Println("Trying to kill it...")
+	ctx, cancel := context.WithTimeout(context.Background(), opTimeout)
+	err := container.Kill(ctx, ctrID)
+	cancel()
+	if err != nil {
+		return actionNone, err
+	}
+	err = upKillCount(svc)
+	if err != nil {
+		return actionKilled, err
+	}
+	log.Println("Trying to run it...")
+	ctx, cancel = context.WithTimeout(context.Background(), opTimeout)
+	defer cancel()
+	err = container.Run(ctx, svc, name)
+	if err != nil {
+		return actionKilled, err
+	}
+	log.Println("Container", name, "started, going to next.")
+	return actionStarted, nil
+}
+
+// recordStatus saves outcome of the check together with
+// current state of replica's container called name.
+func recordStatus(svc *db.Service, name string, action string, actionErr error) {
+	status := db.ReconcileStatus{
+		ServiceId:  svc.ID,
+		LastCheck:  time.Now(),
+		LastAction: action,
+	}
+	if actionErr != nil {
+		errMsg := actionErr.Error()
+		status.LastError = &errMsg
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), opTimeout)
+	defer cancel()
+	ctr, err := container.Get(ctx, name)
+	if err != nil {
+		log.Println(err)
+	}
+	if ctr != nil {
+		status.ContainerID = &ctr.ID
+		if ctr.State != nil {
+			exitCode := ctr.State.ExitCode
+			status.ExitCode = &exitCode
+		}
+		digest, err := container.ImageDigest(ctx, ctr.Image)
+		if err == nil {
+			status.ImageDigest = &digest
+		}
+	}
+	err = db.SaveReconcileStatus(&status)
+	if err != nil {
+		log.Println(err)
 	}
 }
 
@@ -106,8 +265,10 @@ func volLoop(volumes []db.Volume) {
 	for _, volume := range volumes {
 		log.Println("-")
 		log.Printf("Checking volume '%s'\n", volume.Name)
-		exists, err := container.Volume(volume.Name)
+		ctx, cancel := context.WithTimeout(context.Background(), opTimeout)
+		exists, err := container.Volume(ctx, volume.Name)
 		if err != nil {
+			cancel()
 			log.Println(err)
 			log.Println("Going to next volume.")
 			continue
@@ -116,8 +277,13 @@ func volLoop(volumes []db.Volume) {
 			log.Println("Volume", volume.Name, "present.")
 		} else {
 			log.Println("Volume", volume.Name, "not present!")
+			if dryRun {
+				log.Println("Dry run, would create it.")
+				cancel()
+				continue
+			}
 			log.Println("Trying to create it...")
-			err := container.VolumeCreate(volume.Name)
+			err := container.VolumeCreate(ctx, volume.Name)
 			if err != nil {
 				log.Println(err)
 			} else {
@@ -125,9 +291,12 @@ func volLoop(volumes []db.Volume) {
 			}
 			log.Println("Going to next volume.")
 		}
+		cancel()
 	}
 }
 
+// GetResources returns services and volumes controller should reconcile,
+// leaving out paused ones.
 func GetResources() ([]db.Service, []db.Volume, error) {
 	var projects []db.Project
 	err := db.DB.Find(&projects).Error
@@ -136,6 +305,18 @@ func GetResources() ([]db.Service, []db.Volume, error) {
 		return nil, nil, err
 	}
 	log.Println("Found", len(projects), "projects in db.")
+	pausedProjs := map[uint]bool{}
+	stoppedProjs := map[uint]bool{}
+	for _, proj := range projects {
+		if proj.Paused {
+			log.Println("Project", proj.Name, "is paused.")
+			pausedProjs[proj.ID] = true
+		}
+		if proj.DesiredState == db.StateStopped {
+			log.Println("Project", proj.Name, "should be stopped.")
+			stoppedProjs[proj.ID] = true
+		}
+	}
 	var volumes []db.Volume
 	err = db.DB.Find(&volumes).Error
 	if err != nil {
@@ -150,7 +331,25 @@ func GetResources() ([]db.Service, []db.Volume, error) {
 		return nil, nil, err
 	}
 	log.Println("Found", len(services), "services in db.")
-	return services, volumes, nil
+	activeVols := []db.Volume{}
+	for _, vol := range volumes {
+		if !pausedProjs[vol.ProjectId] {
+			activeVols = append(activeVols, vol)
+		}
+	}
+	activeSvcs := []db.Service{}
+	for _, svc := range services {
+		if svc.Paused || pausedProjs[svc.ProjectId] {
+			log.Println("Service", svc.Name, "is paused, skipping.")
+			recordStatus(&svc, container.ReplicaNames(&svc)[0], actionSkipped, nil)
+			continue
+		}
+		if stoppedProjs[svc.ProjectId] {
+			svc.DesiredState = db.StateStopped
+		}
+		activeSvcs = append(activeSvcs, svc)
+	}
+	return activeSvcs, activeVols, nil
 }
 
 func upKillCount(svc *db.Service) error {
@@ -169,6 +368,29 @@ func Run() {
 		log.Println("PLASMA_CONTROLLER_INTERVAL is not valid duration")
 		log.Fatal(err)
 	}
+	if w := os.Getenv("PLASMA_CONTROLLER_WORKERS"); w != "" {
+		workers, err = strconv.Atoi(w)
+		if err != nil || workers < 1 {
+			log.Fatal("PLASMA_CONTROLLER_WORKERS must be a positive number")
+		}
+	}
+	log.Println("Reconciling services with", workers, "workers.")
+	if t := os.Getenv("PLASMA_CONTROLLER_TIMEOUT"); t != "" {
+		opTimeout, err = time.ParseDuration(t)
+		if err != nil {
+			log.Println("PLASMA_CONTROLLER_TIMEOUT is not valid duration")
+			log.Fatal(err)
+		}
+	}
+	dryRun = os.Getenv("PLASMA_CONTROLLER_DRY_RUN") == "true"
+	if dryRun {
+		log.Println("PLASMA_CONTROLLER_DRY_RUN is set, controller will not change anything.")
+	}
+	gc, err := LoadImageGC()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Unused images plasma pulled are removed, keeping", gc.Keep, "last digests of every service.")
 	log.Println("Initializing docker client...")
 	err = container.Init()
 	if err != nil {
@@ -183,6 +405,8 @@ func Run() {
 		}
 		volLoop(volumes)
 		svcLoop(services)
+		orphanLoop()
+		imageLoop()
 		time.Sleep(parsedInterval)
 	}
 }
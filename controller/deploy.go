@@ -0,0 +1,211 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/pgulb/plasma/container"
+	"github.com/pgulb/plasma/db"
+)
+
+// Kinds of DeployEvent.
+const (
+	DeployInfo   = "info"
+	DeployPull   = "pull"
+	DeployCreate = "create"
+	DeployStart  = "start"
+	DeployHealth = "health"
+	DeployReady  = "ready"
+	DeployFailed = "failed"
+)
+
+// DeployEvent is progress of deploying one of project's services.
+type DeployEvent struct {
+	Service   string
+	Container string // empty for events about whole service
+	Kind      string
+	Message   string
+	// pull progress of one image layer
+	Layer   string
+	Current int64
+	Total   int64
+	// health status after transition, see container's State.Health
+	Health string
+}
+
+var ErrDeployFailed = errors.New("deploy failed")
+
+// Deploy runs project's services right away instead of waiting for the
+// controller and waits until all their replicas are healthy, reporting
+// progress of each. It returns an error if any service fails or ctx expires.
+func Deploy(ctx context.Context, projName string, report func(DeployEvent)) error {
+	proj, services, volumes, err := db.ProjectResources(projName)
+	if err != nil {
+		return err
+	}
+	if proj.DesiredState == db.StateStopped {
+		return fmt.Errorf("%w, start it first", db.ErrProjectStopped)
+	}
+	// services are deployed concurrently, report one event at a time
+	var reportMu sync.Mutex
+	send := func(event DeployEvent) {
+		reportMu.Lock()
+		defer reportMu.Unlock()
+		report(event)
+	}
+	for _, vol := range volumes {
+		exists, err := container.Volume(ctx, vol.Name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		err = container.VolumeCreate(ctx, vol.Name)
+		if err != nil {
+			return err
+		}
+		send(DeployEvent{Kind: DeployInfo, Message: "volume " + vol.Name + " created"})
+	}
+	errs := make([]error, len(services))
+	var wg sync.WaitGroup
+	for i := range services {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = deployService(ctx, &services[i], proj.Paused, send)
+		}()
+	}
+	wg.Wait()
+	err = errors.Join(errs...)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDeployFailed, err)
+	}
+	return nil
+}
+
+// deployService starts replicas of the service which are not running
+// and waits for all of them to become healthy.
+func deployService(ctx context.Context, svc *db.Service, projPaused bool, send func(DeployEvent)) error {
+	fail := func(name string, err error) error {
+		log.Println(err)
+		send(DeployEvent{Service: svc.Name, Container: name, Kind: DeployFailed, Message: err.Error()})
+		return fmt.Errorf("%s: %w", svc.Name, err)
+	}
+	if svc.Paused || projPaused {
+		send(DeployEvent{Service: svc.Name, Kind: DeployInfo, Message: "paused, skipped"})
+		return nil
+	}
+	if svc.DesiredState == db.StateStopped {
+		send(DeployEvent{Service: svc.Name, Kind: DeployInfo, Message: "stopped, skipped"})
+		return nil
+	}
+	if svc.Image == "" {
+		return fail("", errors.New("service has no image"))
+	}
+	unlock := LockService(svc.Name)
+	defer unlock()
+	log.Println("Deploying service", svc.Name, "on demand.")
+	err := container.EnsureImage(ctx, svc, func(msg jsonmessage.JSONMessage) {
+		event := DeployEvent{Service: svc.Name, Kind: DeployPull, Layer: msg.ID, Message: msg.Status}
+		if msg.Progress != nil {
+			event.Current = msg.Progress.Current
+			event.Total = msg.Progress.Total
+		}
+		send(event)
+	})
+	if err != nil {
+		return fail("", err)
+	}
+	names := container.ReplicaNames(svc)
+	for _, name := range names {
+		action, err := deployReplica(ctx, svc, name, send)
+		recordStatus(svc, name, action, err)
+		if err != nil {
+			return fail(name, err)
+		}
+	}
+	for _, name := range names {
+		err := waitReady(ctx, svc, name, send)
+		if err != nil {
+			return fail(name, err)
+		}
+	}
+	return nil
+}
+
+// deployReplica creates and starts replica's container, replacing
+// the existing one if it's not running, and returns the action it took.
+func deployReplica(ctx context.Context, svc *db.Service, name string, send func(DeployEvent)) (string, error) {
+	ctr, err := container.Get(ctx, name)
+	if err != nil {
+		return actionNone, err
+	}
+	present, alive, _ := container.IsPresentAliveAndHealthy(svc, ctr)
+	if alive {
+		send(DeployEvent{Service: svc.Name, Container: name, Kind: DeployInfo, Message: "already running"})
+		return actionNone, nil
+	}
+	if present {
+		err := container.ForceRemove(ctx, ctr.ID)
+		if err != nil {
+			return actionNone, err
+		}
+	}
+	ctrID, err := container.Create(ctx, svc, name)
+	if err != nil {
+		return actionNone, err
+	}
+	send(DeployEvent{Service: svc.Name, Container: name, Kind: DeployCreate, Message: "created"})
+	err = container.Start(ctx, ctrID)
+	if err != nil {
+		return actionNone, err
+	}
+	send(DeployEvent{Service: svc.Name, Container: name, Kind: DeployStart, Message: "started"})
+	return actionStarted, nil
+}
+
+// waitReady polls replica's container until it's running and healthy,
+// reporting every change of its health status.
+func waitReady(ctx context.Context, svc *db.Service, name string, send func(DeployEvent)) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var lastHealth string
+	for {
+		ctr, err := container.Get(ctx, name)
+		if err != nil {
+			return err
+		}
+		present, alive, healthy := container.IsPresentAliveAndHealthy(svc, ctr)
+		if !present {
+			return fmt.Errorf("container %s is gone", name)
+		}
+		if !alive {
+			return fmt.Errorf("container %s is not running (exit code %v)", name, ctr.State.ExitCode)
+		}
+		if ctr.State.Health != nil && ctr.State.Health.Status != lastHealth {
+			lastHealth = ctr.State.Health.Status
+			send(DeployEvent{
+				Service:   svc.Name,
+				Container: name,
+				Kind:      DeployHealth,
+				Message:   lastHealth,
+				Health:    lastHealth,
+			})
+		}
+		if healthy {
+			send(DeployEvent{Service: svc.Name, Container: name, Kind: DeployReady, Message: "ready"})
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("container %s did not become healthy: %w", name, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/containerd/errdefs"
+	"github.com/docker/go-units"
+	"github.com/pgulb/plasma/container"
+	"github.com/pgulb/plasma/db"
+)
+
+// Statuses of ManagedImage.
+const (
+	ImageInUse   = "in use"  // some container was created from it
+	ImageCurrent = "current" // service runs it, e.g. when its containers are recreated
+	ImageKept    = "kept"    // one of last digests of a service, kept for rollback
+	ImageUnused  = "unused"
+	ImageMissing = "missing" // removed from docker by someone else
+)
+
+// ManagedImage is an image plasma pulled, pushed or built.
+type ManagedImage struct {
+	Ref       string    `json:"ref"`
+	ID        string    `json:"id"`
+	Source    string    `json:"source"`
+	Size      int64     `json:"size"`
+	UpdatedAt time.Time `json:"updated_at"` // when it was last pulled, pushed or built
+	Status    string    `json:"status"`
+	Services  []string  `json:"services"` // services it's current or kept for
+}
+
+// ImageGC configures removal of unused images plasma pulled,
+// set with PLASMA_IMAGE_GC_* environment variables.
+type ImageGC struct {
+	Keep     int           // how many last digests of every service are kept
+	MaxAge   time.Duration // unused images pulled longer ago are removed, 0 disables
+	MaxSize  int64         // unused images are removed, oldest first, while plasma's images take more, 0 disables
+	Interval time.Duration // how often images are checked
+}
+
+// read-only once LoadImageGC returns
+var imageGC = ImageGC{Keep: 3, MaxAge: 7 * 24 * time.Hour, Interval: time.Hour}
+
+var (
+	imageGCOnce sync.Once
+	imageGCErr  error
+)
+
+var lastImageGC time.Time
+
+// LoadImageGC reads image GC settings from environment, keeping defaults
+// for ones which are not set. Settings are read only once, as server
+// and controller both need them and start concurrently.
+func LoadImageGC() (ImageGC, error) {
+	imageGCOnce.Do(func() {
+		imageGCErr = loadImageGC(&imageGC)
+	})
+	return imageGC, imageGCErr
+}
+
+func loadImageGC(gc *ImageGC) error {
+	if k := os.Getenv("PLASMA_IMAGE_GC_KEEP"); k != "" {
+		keep, err := strconv.Atoi(k)
+		if err != nil || keep < 1 {
+			return errors.New("PLASMA_IMAGE_GC_KEEP must be a positive number")
+		}
+		gc.Keep = keep
+	}
+	if a := os.Getenv("PLASMA_IMAGE_GC_MAX_AGE"); a != "" {
+		maxAge, err := time.ParseDuration(a)
+		if err != nil {
+			return fmt.Errorf("PLASMA_IMAGE_GC_MAX_AGE is not valid duration: %w", err)
+		}
+		gc.MaxAge = maxAge
+	}
+	if s := os.Getenv("PLASMA_IMAGE_GC_MAX_SIZE"); s != "" {
+		maxSize, err := units.FromHumanSize(s)
+		if err != nil {
+			return fmt.Errorf("PLASMA_IMAGE_GC_MAX_SIZE is not valid size, e.g. 10GB: %w", err)
+		}
+		gc.MaxSize = maxSize
+	}
+	if i := os.Getenv("PLASMA_IMAGE_GC_INTERVAL"); i != "" {
+		interval, err := time.ParseDuration(i)
+		if err != nil {
+			return fmt.Errorf("PLASMA_IMAGE_GC_INTERVAL is not valid duration: %w", err)
+		}
+		gc.Interval = interval
+	}
+	return nil
+}
+
+// ManagedImages lists images plasma knows about with their size and whether
+// they are still needed. Image counts as needed through any of its refs.
+func ManagedImages(ctx context.Context) ([]ManagedImage, error) {
+	imgs, err := db.Images()
+	if err != nil {
+		return nil, err
+	}
+	local, err := container.ImagesByID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	inUse, err := container.ImagesInUse(ctx)
+	if err != nil {
+		return nil, err
+	}
+	history, err := db.PinHistory(imageGC.Keep)
+	if err != nil {
+		return nil, err
+	}
+	var services []db.Service
+	err = db.DB.Find(&services).Error
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	// refs to services needing them
+	current := map[string][]string{}
+	for _, svc := range services {
+		current[svc.ImageRef()] = append(current[svc.ImageRef()], svc.Name)
+	}
+	kept := map[string][]string{}
+	for svcName, pins := range history {
+		for _, pin := range pins {
+			kept[pin] = append(kept[pin], svcName)
+		}
+	}
+	currentIDs := map[string]bool{}
+	keptIDs := map[string]bool{}
+	for _, img := range imgs {
+		if len(current[img.Ref]) > 0 {
+			currentIDs[img.ImageID] = true
+		}
+		if len(kept[img.Ref]) > 0 {
+			keptIDs[img.ImageID] = true
+		}
+	}
+	managed := []ManagedImage{}
+	for _, img := range imgs {
+		summary, present := local[img.ImageID]
+		svcNames := append(slices.Clone(current[img.Ref]), kept[img.Ref]...)
+		slices.Sort(svcNames)
+		m := ManagedImage{
+			Ref:       img.Ref,
+			ID:        img.ImageID,
+			Source:    img.Source,
+			Size:      summary.Size,
+			UpdatedAt: img.UpdatedAt,
+			Services:  slices.Compact(svcNames),
+		}
+		switch {
+		case !present:
+			m.Status = ImageMissing
+		case inUse[img.ImageID]:
+			m.Status = ImageInUse
+		case currentIDs[img.ImageID]:
+			m.Status = ImageCurrent
+		case keptIDs[img.ImageID]:
+			m.Status = ImageKept
+		default:
+			m.Status = ImageUnused
+		}
+		managed = append(managed, m)
+	}
+	return managed, nil
+}
+
+// ImagesSize returns how much space images take,
+// counting images with many refs once.
+func ImagesSize(imgs []ManagedImage) int64 {
+	var total int64
+	seen := map[string]bool{}
+	for _, img := range imgs {
+		if img.Status == ImageMissing || seen[img.ID] {
+			continue
+		}
+		seen[img.ID] = true
+		total += img.Size
+	}
+	return total
+}
+
+// collectImages removes unused images plasma pulled: all pulled longer than
+// MaxAge ago, then oldest first while plasma's images take more than MaxSize.
+// Images plasma pushed or built are never removed, neither are images
+// sharing ID with them. Pulled images removed by someone else are forgotten.
+func collectImages(ctx context.Context) error {
+	imgs, err := ManagedImages(ctx)
+	if err != nil {
+		return err
+	}
+	// image can be removed only if all of its refs can
+	refs := map[string][]string{}
+	removable := map[string]bool{}
+	pulledAt := map[string]time.Time{}
+	for _, img := range imgs {
+		if img.Status == ImageMissing {
+			if img.Source == db.ImageSourcePull && !dryRun {
+				log.Println("Image", img.Ref, "is gone, forgetting it.")
+				err := db.DeleteImage(img.Ref)
+				if err != nil {
+					log.Println(err)
+				}
+			}
+			continue
+		}
+		if _, seen := removable[img.ID]; !seen {
+			removable[img.ID] = true
+		}
+		if img.Source != db.ImageSourcePull || img.Status != ImageUnused {
+			removable[img.ID] = false
+		}
+		refs[img.ID] = append(refs[img.ID], img.Ref)
+		if img.UpdatedAt.After(pulledAt[img.ID]) {
+			pulledAt[img.ID] = img.UpdatedAt
+		}
+	}
+	var candidates []string
+	for id, ok := range removable {
+		if ok {
+			candidates = append(candidates, id)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return pulledAt[candidates[i]].Before(pulledAt[candidates[j]])
+	})
+	total := ImagesSize(imgs)
+	sizes := map[string]int64{}
+	for _, img := range imgs {
+		sizes[img.ID] = img.Size
+	}
+	for _, id := range candidates {
+		var reason string
+		switch {
+		case imageGC.MaxAge > 0 && time.Since(pulledAt[id]) > imageGC.MaxAge:
+			reason = "pulled more than " + imageGC.MaxAge.String() + " ago"
+		case imageGC.MaxSize > 0 && total > imageGC.MaxSize:
+			reason = "images take " + units.HumanSize(float64(total)) +
+				", more than " + units.HumanSize(float64(imageGC.MaxSize))
+		default:
+			continue
+		}
+		if dryRun {
+			log.Println("Dry run, would remove unused image", refs[id], "-", reason)
+			total -= sizes[id]
+			continue
+		}
+		log.Println("Removing unused image", refs[id], "-", reason)
+		removed := true
+		for _, ref := range refs[id] {
+			err := container.ImageRemove(ctx, ref)
+			if err != nil && !errdefs.IsNotFound(err) {
+				log.Println(err)
+				removed = false
+				break
+			}
+			err = db.DeleteImage(ref)
+			if err != nil {
+				removed = false
+				break
+			}
+		}
+		if removed {
+			total -= sizes[id]
+		}
+	}
+	return nil
+}
+
+// imageLoop runs image GC once in a while.
+func imageLoop() {
+	if time.Since(lastImageGC) < imageGC.Interval {
+		return
+	}
+	lastImageGC = time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), opTimeout)
+	defer cancel()
+	err := collectImages(ctx)
+	if err != nil {
+		log.Println(err)
+	}
+}
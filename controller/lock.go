@@ -0,0 +1,38 @@
+package controller
+
+import "sync"
+
+var (
+	svcLocksMu sync.Mutex
+	svcLocks   = map[string]*sync.Mutex{}
+)
+
+// LockService takes the per-service lock, so the controller and API
+// never act on the same service at once. Call the returned func to unlock.
+func LockService(name string) func() {
+	svcLocksMu.Lock()
+	l, ok := svcLocks[name]
+	if !ok {
+		l = &sync.Mutex{}
+		svcLocks[name] = l
+	}
+	svcLocksMu.Unlock()
+	l.Lock()
+	return l.Unlock
+}
+
+// TryLockService is like LockService, but does not wait.
+// It returns nil if the service is already locked.
+func TryLockService(name string) func() {
+	svcLocksMu.Lock()
+	l, ok := svcLocks[name]
+	if !ok {
+		l = &sync.Mutex{}
+		svcLocks[name] = l
+	}
+	svcLocksMu.Unlock()
+	if !l.TryLock() {
+		return nil
+	}
+	return l.Unlock
+}
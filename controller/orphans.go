@@ -0,0 +1,240 @@
+package controller
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/pgulb/plasma/container"
+	"github.com/pgulb/plasma/db"
+)
+
+type OrphanContainer struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	State   string `json:"state"`
+	Service string `json:"service"`
+}
+
+type OrphanImage struct {
+	ID   string `json:"id"`
+	Size int64  `json:"size"`
+}
+
+type Orphans struct {
+	Containers []OrphanContainer `json:"containers"`
+	Volumes    []string          `json:"volumes"`
+	Networks   []string          `json:"networks"`
+	Images     []OrphanImage     `json:"images"`
+}
+
+type PruneOpts struct {
+	Containers bool
+	Volumes    bool
+	Networks   bool
+	Images     bool
+	DryRun     bool
+}
+
+// FindOrphans lists plasma-labeled containers, volumes and networks that
+// have no matching row in db, plus dangling images plasma pulled, including
+// ones left behind when later pulls moved their tags. Docker is listed before db is read, so resources of services and projects
+// created in the meantime are not reported.
+func FindOrphans(ctx context.Context) (*Orphans, error) {
+	ctrs, err := container.ListManaged(ctx)
+	if err != nil {
+		return nil, err
+	}
+	vols, err := container.ListManagedVolumes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	nets, err := container.ListManagedNetworks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	imgs, err := container.DanglingImages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	knownSvcs, err := knownServices()
+	if err != nil {
+		return nil, err
+	}
+	var volumes []db.Volume
+	err = db.DB.Find(&volumes).Error
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	knownVols := map[string]bool{}
+	for _, vol := range volumes {
+		knownVols[vol.Name] = true
+	}
+	var projects []db.Project
+	err = db.DB.Find(&projects).Error
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	knownNets := map[string]bool{}
+	for _, proj := range projects {
+		knownNets[container.NetworkName(proj.Name)] = true
+	}
+	pulledImgs, err := db.PulledImageIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	orphans := Orphans{
+		Containers: []OrphanContainer{},
+		Volumes:    []string{},
+		Networks:   []string{},
+		Images:     []OrphanImage{},
+	}
+	for _, ctr := range ctrs {
+		svcName := ctr.Labels[container.LabelService]
+		if knownSvcs[svcName] {
+			continue
+		}
+		var name string
+		if len(ctr.Names) > 0 {
+			name = strings.TrimPrefix(ctr.Names[0], "/")
+		}
+		orphans.Containers = append(orphans.Containers, OrphanContainer{
+			ID:      ctr.ID,
+			Name:    name,
+			State:   ctr.State,
+			Service: svcName,
+		})
+	}
+	for _, vol := range vols {
+		if !knownVols[vol.Name] {
+			orphans.Volumes = append(orphans.Volumes, vol.Name)
+		}
+	}
+	for _, net := range nets {
+		if !knownNets[net.Name] {
+			orphans.Networks = append(orphans.Networks, net.Name)
+		}
+	}
+	// other dangling images are not plasma's to remove
+	for _, img := range imgs {
+		if pulledImgs[img.ID] {
+			orphans.Images = append(orphans.Images, OrphanImage{ID: img.ID, Size: img.Size})
+		}
+	}
+	return &orphans, nil
+}
+
+func knownServices() (map[string]bool, error) {
+	var services []db.Service
+	err := db.DB.Find(&services).Error
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	known := map[string]bool{}
+	for _, svc := range services {
+		known[svc.Name] = true
+	}
+	return known, nil
+}
+
+// Prune removes orphans of selected kinds and returns what was removed,
+// or what would be removed when opts.DryRun is set.
+func Prune(ctx context.Context, opts PruneOpts) (*Orphans, error) {
+	orphans, err := FindOrphans(ctx)
+	if err != nil {
+		return nil, err
+	}
+	removed := Orphans{
+		Containers: []OrphanContainer{},
+		Volumes:    []string{},
+		Networks:   []string{},
+		Images:     []OrphanImage{},
+	}
+	// containers go first, so volumes, networks and images they used can be removed
+	if opts.Containers {
+		// services could be created since orphans were found
+		knownSvcs, err := knownServices()
+		if err != nil {
+			return nil, err
+		}
+		for _, ctr := range orphans.Containers {
+			if knownSvcs[ctr.Service] {
+				continue
+			}
+			if !opts.DryRun {
+				log.Println("Removing orphaned container", ctr.Name)
+				err := container.ForceRemove(ctx, ctr.ID)
+				if err != nil {
+					log.Println(err)
+					continue
+				}
+			}
+			removed.Containers = append(removed.Containers, ctr)
+		}
+	}
+	if opts.Volumes {
+		for _, vol := range orphans.Volumes {
+			if !opts.DryRun {
+				log.Println("Removing orphaned volume", vol)
+				err := container.VolumeRemove(ctx, vol)
+				if err != nil {
+					log.Println(err)
+					continue
+				}
+			}
+			removed.Volumes = append(removed.Volumes, vol)
+		}
+	}
+	if opts.Networks {
+		for _, net := range orphans.Networks {
+			if !opts.DryRun {
+				log.Println("Removing orphaned network", net)
+				err := container.NetworkRemove(ctx, net)
+				if err != nil {
+					log.Println(err)
+					continue
+				}
+			}
+			removed.Networks = append(removed.Networks, net)
+		}
+	}
+	if opts.Images {
+		for _, img := range orphans.Images {
+			if !opts.DryRun {
+				log.Println("Removing dangling image", img.ID)
+				err := container.ImageRemove(ctx, img.ID)
+				if err != nil {
+					log.Println(err)
+					continue
+				}
+				err = db.ForgetReplacedImage(img.ID)
+				if err != nil {
+					log.Println(err)
+				}
+			}
+			removed.Images = append(removed.Images, img)
+		}
+	}
+	return &removed, nil
+}
+
+func orphanLoop() {
+	ctx, cancel := context.WithTimeout(context.Background(), opTimeout)
+	defer cancel()
+	orphans, err := FindOrphans(ctx)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if len(orphans.Containers) > 0 || len(orphans.Volumes) > 0 || len(orphans.Networks) > 0 {
+		log.Println(
+			"Found", len(orphans.Containers), "orphaned containers,",
+			len(orphans.Volumes), "orphaned volumes and",
+			len(orphans.Networks), "orphaned networks, use 'plasma prune' to remove them.",
+		)
+	}
+}
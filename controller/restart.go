@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/pgulb/plasma/container"
+	"github.com/pgulb/plasma/db"
+)
+
+var ErrServiceStopped = fmt.Errorf("service should be %s", db.StateStopped)
+
+// RestartService gracefully stops service's containers and runs them again
+// one replica at a time, optionally pulling the image first. It waits for
+// each new container to become healthy until timeout expires.
+func RestartService(ctx context.Context, svc *db.Service, pull bool, timeout time.Duration) error {
+	if svc.DesiredState == db.StateStopped {
+		return fmt.Errorf("%w, start it first", ErrServiceStopped)
+	}
+	unlock := LockService(svc.Name)
+	defer unlock()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	log.Println("Restarting service", svc.Name, "on demand.")
+	if pull {
+		err := container.Pull(ctx, svc, nil)
+		if err != nil {
+			recordStatus(svc, container.ReplicaNames(svc)[0], actionNone, err)
+			return err
+		}
+	}
+	for _, name := range container.ReplicaNames(svc) {
+		action, err := restartReplica(ctx, svc, name)
+		recordStatus(svc, name, action, err)
+		if err != nil {
+			return err
+		}
+	}
+	log.Println("Service", svc.Name, "restarted and healthy.")
+	return nil
+}
+
+// RemoveService stops and removes all containers of service
+// that was deleted from db.
+func RemoveService(svc *db.Service) error {
+	unlock := LockService(svc.Name)
+	defer unlock()
+	log.Println("Removing containers of deleted service", svc.Name)
+	_, err := stop(svc, nil)
+	return err
+}
+
+// restartReplica replaces replica's container and waits until the new one
+// is healthy or ctx is done. Replacing it is not tied to ctx, so client
+// disconnecting can't leave replica stopped.
+func restartReplica(ctx context.Context, svc *db.Service, name string) (string, error) {
+	opCtx, cancel := context.WithTimeout(context.Background(), opTimeout)
+	defer cancel()
+	ctr, err := container.Get(opCtx, name)
+	if err != nil {
+		return actionNone, err
+	}
+	if ctr != nil {
+		log.Println("Stopping", name, "...")
+		err := container.Stop(opCtx, ctr.ID)
+		if err != nil {
+			return actionNone, err
+		}
+	}
+	log.Println("Running", name, "...")
+	err = container.Run(opCtx, svc, name)
+	if err != nil {
+		return actionStopped, err
+	}
+	err = container.WaitHealthy(ctx, svc, name)
+	if err != nil {
+		return actionStarted, err
+	}
+	return actionStarted, nil
+}
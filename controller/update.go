@@ -0,0 +1,274 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pgulb/plasma/container"
+	"github.com/pgulb/plasma/db"
+)
+
+const (
+	defaultUpdateTimeout = 2 * time.Minute
+	defaultUpdateMonitor = 5 * time.Second
+	// suffix of containers started next to old ones during rolling and blue-green updates
+	nextSuffix = "-plasma-next"
+)
+
+var errRolledBack = errors.New("update rolled back")
+
+// update replaces running containers of service created from outdated spec,
+// using service's update strategy. Rolling and blue-green updates only remove
+// old containers once new ones are healthy, otherwise new ones are removed and
+// spec is marked as failed, so it's not retried until it changes, and spec
+// from before apply is restored, with replicas already updated to failed one.
+func update(svc *db.Service, names []string) (string, error) {
+	hash := svc.SpecHash()
+	if svc.FailedSpecHash != nil && *svc.FailedSpecHash == hash {
+		log.Println("Update of", svc.Name, "to current spec failed before, skipping.")
+		if svc.PreviousSpec != nil && !dryRun {
+			restorePreviousSpec(svc)
+		}
+		return actionNone, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), opTimeout)
+	ctrs, err := container.ListService(ctx, svc.Name)
+	cancel()
+	if err != nil {
+		return actionNone, err
+	}
+	outdated := map[string]string{} // name -> container ID
+	for _, ctr := range ctrs {
+		name := strings.TrimPrefix(ctr.Names[0], "/")
+		// containers created before spec hash was tracked are left alone
+		ctrHash, ok := ctr.Labels[container.LabelSpecHash]
+		if slices.Contains(names, name) && ctr.State == "running" && ok && ctrHash != hash {
+			outdated[name] = ctr.ID
+		}
+	}
+	if len(outdated) == 0 {
+		if svc.PreviousSpec != nil && !dryRun {
+			err := db.ClearPreviousSpec(svc)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+		return actionNone, nil
+	}
+	strategy := svc.UpdateStrategy
+	if strategy != db.UpdateRecreate {
+		publishes, err := svc.PublishesPorts()
+		if err != nil {
+			return actionNone, err
+		}
+		if publishes {
+			log.Println("Service", svc.Name, "publishes host ports, old and new containers can't run together.")
+			strategy = db.UpdateRecreate
+		}
+	}
+	log.Println("Service", svc.Name, "has", len(outdated), "outdated containers, updating using", strategy, "strategy.")
+	if dryRun {
+		log.Println("Dry run, would update them.")
+		return actionWouldUpdate, nil
+	}
+	// keep replicas order
+	toUpdate := []string{}
+	for _, name := range names {
+		if _, ok := outdated[name]; ok {
+			toUpdate = append(toUpdate, name)
+		}
+	}
+	switch strategy {
+	case db.UpdateRolling:
+		err = updateRolling(svc, toUpdate, outdated)
+	case db.UpdateBlueGreen:
+		err = updateBlueGreen(svc, toUpdate, outdated)
+	default:
+		err = updateRecreate(svc, toUpdate, outdated)
+	}
+	if errors.Is(err, errRolledBack) {
+		addEvent(svc, "rollback", fmt.Sprintf("update of %s rolled back: %s", svc.Name, err))
+		if err := db.SetFailedSpecHash(svc, &hash); err != nil {
+			log.Println(err)
+		}
+		svc.FailedSpecHash = &hash
+		if svc.PreviousSpec != nil {
+			restorePreviousSpec(svc)
+		}
+		return actionRolledBack, err
+	}
+	if err != nil {
+		return actionNone, err
+	}
+	if svc.PreviousSpec != nil {
+		err := db.ClearPreviousSpec(svc)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	addEvent(svc, "update", fmt.Sprintf(
+		"updated %v containers of %s using %s strategy", len(toUpdate), svc.Name, strategy,
+	))
+	return actionUpdated, nil
+}
+
+func updateRecreate(svc *db.Service, names []string, outdated map[string]string) error {
+	for _, name := range names {
+		ctx, cancel := context.WithTimeout(context.Background(), opTimeout)
+		log.Println("Recreating", name, "...")
+		err := container.Stop(ctx, outdated[name])
+		if err == nil {
+			err = container.Run(ctx, svc, name)
+		}
+		cancel()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func updateRolling(svc *db.Service, names []string, outdated map[string]string) error {
+	for _, name := range names {
+		log.Println("Updating", name, "...")
+		err := startNext(svc, name)
+		if err != nil {
+			discardNext(name)
+			return fmt.Errorf("%w: %s", errRolledBack, err)
+		}
+		err = promoteNext(name, outdated[name])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func updateBlueGreen(svc *db.Service, names []string, outdated map[string]string) error {
+	for _, name := range names {
+		log.Println("Starting new container for", name, "...")
+		err := startNext(svc, name)
+		if err != nil {
+			for _, name := range names {
+				discardNext(name)
+			}
+			return fmt.Errorf("%w: %s", errRolledBack, err)
+		}
+	}
+	for _, name := range names {
+		err := promoteNext(name, outdated[name])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restorePreviousSpec brings back service's spec from before apply, so its
+// containers are not recreated from failed one, and updates replicas
+// which were already updated to failed spec back to it.
+func restorePreviousSpec(svc *db.Service) {
+	err := db.RestorePreviousSpec(svc)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	addEvent(svc, "rollback", "restored spec of "+svc.Name+" from before apply")
+	_, err = update(svc, container.ReplicaNames(svc))
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// startNext runs new container next to replica's current one
+// and waits until it is healthy.
+func startNext(svc *db.Service, name string) error {
+	timeout := defaultUpdateTimeout
+	if svc.UpdateTimeout != nil {
+		timeout = *svc.UpdateTimeout
+	}
+	monitor := defaultUpdateMonitor
+	if svc.UpdateMonitor != nil {
+		monitor = *svc.UpdateMonitor
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), opTimeout+timeout+monitor)
+	defer cancel()
+	nextName := name + nextSuffix
+	// leftover from interrupted update
+	discardNext(name)
+	err := container.Run(ctx, svc, nextName)
+	if err != nil {
+		return err
+	}
+	healthCtx, healthCancel := context.WithTimeout(ctx, timeout)
+	defer healthCancel()
+	err = container.WaitHealthy(healthCtx, svc, nextName)
+	if err != nil {
+		return err
+	}
+	// container can crash right after it becomes healthy
+	time.Sleep(monitor)
+	ctr, err := container.Get(ctx, nextName)
+	if err != nil {
+		return err
+	}
+	present, alive, healthy := container.IsPresentAliveAndHealthy(svc, ctr)
+	if !present || !alive || !healthy {
+		return fmt.Errorf("container %s did not stay healthy for %s", nextName, monitor)
+	}
+	return nil
+}
+
+// promoteNext replaces replica's old container with the new one.
+func promoteNext(name string, oldID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), opTimeout)
+	defer cancel()
+	nextName := name + nextSuffix
+	next, err := container.Get(ctx, nextName)
+	if err != nil {
+		return err
+	}
+	if next == nil {
+		return fmt.Errorf("container %s is gone", nextName)
+	}
+	err = container.Stop(ctx, oldID)
+	if err != nil {
+		return err
+	}
+	return container.Rename(ctx, next.ID, name)
+}
+
+func discardNext(name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), opTimeout)
+	defer cancel()
+	next, err := container.Get(ctx, name+nextSuffix)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if next == nil {
+		return
+	}
+	log.Println("Removing", name+nextSuffix, "...")
+	err = container.ForceRemove(ctx, next.ID)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func addEvent(svc *db.Service, kind string, msg string) {
+	err := db.AddEvent(&db.Event{
+		ProjectId: svc.ProjectId,
+		ServiceId: svc.ID,
+		Kind:      kind,
+		Message:   msg,
+	})
+	if err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,173 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/compose-spec/compose-go/v2/types"
+)
+
+// AutoUpdateInDB is parsed from x-plasma-auto-update compose extension.
+type AutoUpdateInDB struct {
+	Interval time.Duration `json:"interval"` // how often registry is checked for new digest
+	Windows  []string      `json:"windows"`  // maintenance windows, updates are allowed anytime if empty
+}
+
+type autoUpdateExt struct {
+	Interval string   `mapstructure:"interval"`
+	Windows  []string `mapstructure:"windows"`
+}
+
+const AutoUpdateExtension = "x-plasma-auto-update"
+
+const defaultAutoUpdateInterval = time.Hour
+
+// autoUpdateFromCompose parses x-plasma-auto-update extension, which is
+// either true for defaults or a mapping with interval and windows.
+func autoUpdateFromCompose(svc types.ServiceConfig) (*AutoUpdateInDB, error) {
+	raw, found := svc.Extensions[AutoUpdateExtension]
+	if !found {
+		return nil, nil
+	}
+	autoUpdate := AutoUpdateInDB{Interval: defaultAutoUpdateInterval}
+	if enabled, ok := raw.(bool); ok {
+		if !enabled {
+			return nil, nil
+		}
+		return &autoUpdate, nil
+	}
+	var ext autoUpdateExt
+	_, err := svc.Extensions.Get(AutoUpdateExtension, &ext)
+	if err != nil {
+		return nil, fmt.Errorf("service %s: %s: %w", svc.Name, AutoUpdateExtension, err)
+	}
+	if ext.Interval != "" {
+		autoUpdate.Interval, err = time.ParseDuration(ext.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %s: interval: %w", svc.Name, AutoUpdateExtension, err)
+		}
+	}
+	for _, w := range ext.Windows {
+		_, err := parseWindow(w)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %s: windows: %w", svc.Name, AutoUpdateExtension, err)
+		}
+	}
+	autoUpdate.Windows = ext.Windows
+	return &autoUpdate, nil
+}
+
+// window is a maintenance window, e.g. "Mon-Fri 02:00-04:00", "Sat,Sun 00:00-06:00"
+// or "22:00-02:00" for every day. Window ending before it starts ends
+// on the next day. Times are in server's local time zone.
+type window struct {
+	days  [7]bool // indexed by time.Weekday
+	start int     // minutes since midnight
+	end   int
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func parseWindow(s string) (window, error) {
+	var w window
+	fields := strings.Fields(s)
+	var days, hours string
+	switch len(fields) {
+	case 1:
+		hours = fields[0]
+		for i := range w.days {
+			w.days[i] = true
+		}
+	case 2:
+		days, hours = fields[0], fields[1]
+	default:
+		return w, fmt.Errorf("window '%s' is not like 'Mon-Fri 02:00-04:00'", s)
+	}
+	for _, part := range strings.Split(days, ",") {
+		if part == "" {
+			continue
+		}
+		from, to, isRange := strings.Cut(part, "-")
+		first, ok := weekdays[strings.ToLower(from)]
+		if !ok {
+			return w, fmt.Errorf("window '%s': unknown day '%s'", s, from)
+		}
+		last := first
+		if isRange {
+			last, ok = weekdays[strings.ToLower(to)]
+			if !ok {
+				return w, fmt.Errorf("window '%s': unknown day '%s'", s, to)
+			}
+		}
+		// ranges can wrap around the week, e.g. Fri-Mon
+		for d := first; ; d = (d + 1) % 7 {
+			w.days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	start, end, ok := strings.Cut(hours, "-")
+	if !ok {
+		return w, fmt.Errorf("window '%s': hours are not like '02:00-04:00'", s)
+	}
+	var err error
+	w.start, err = parseClock(start)
+	if err != nil {
+		return w, fmt.Errorf("window '%s': %w", s, err)
+	}
+	w.end, err = parseClock(end)
+	if err != nil {
+		return w, fmt.Errorf("window '%s': %w", s, err)
+	}
+	return w, nil
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("time '%s' is not like '02:00'", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (w window) contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	switch {
+	case w.start == w.end:
+		return w.days[day]
+	case w.start < w.end:
+		return w.days[day] && m >= w.start && m < w.end
+	default:
+		yesterday := (day + 6) % 7
+		return (w.days[day] && m >= w.start) || (w.days[yesterday] && m < w.end)
+	}
+}
+
+// InWindow reports if updates are allowed at t, that is if t is inside
+// any of maintenance windows or there are none.
+func (a *AutoUpdateInDB) InWindow(t time.Time) bool {
+	if len(a.Windows) == 0 {
+		return true
+	}
+	for _, s := range a.Windows {
+		w, err := parseWindow(s)
+		if err != nil {
+			continue
+		}
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
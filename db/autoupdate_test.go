@@ -0,0 +1,78 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// at returns time in January 2024, which starts on Monday.
+func at(day int, clock string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04", fmt.Sprintf("2024-01-%02d %s", day, clock), time.Local)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestWindowContains(t *testing.T) {
+	tests := []struct {
+		name   string
+		window string
+		t      time.Time
+		want   bool
+	}{
+		{"range inside days", "Mon-Fri 02:00-04:00", at(3, "02:30"), true},
+		{"range before start", "Mon-Fri 02:00-04:00", at(3, "01:59"), false},
+		{"range end is excluded", "Mon-Fri 02:00-04:00", at(3, "04:00"), false},
+		{"range on other day", "Mon-Fri 02:00-04:00", at(6, "02:30"), false},
+		{"list of days", "Sat,Sun 00:00-06:00", at(7, "05:59"), true},
+		{"every day", "22:00-02:00", at(2, "23:00"), true},
+
+		{"week wrapping range on friday", "Fri-Mon 02:00-04:00", at(5, "03:00"), true},
+		{"week wrapping range on sunday", "Fri-Mon 02:00-04:00", at(7, "03:00"), true},
+		{"week wrapping range on monday", "Fri-Mon 02:00-04:00", at(8, "03:00"), true},
+		{"week wrapping range on tuesday", "Fri-Mon 02:00-04:00", at(9, "03:00"), false},
+		{"week wrapping range on thursday", "Fri-Mon 02:00-04:00", at(4, "03:00"), false},
+
+		{"across midnight before it", "Fri 22:00-02:00", at(5, "23:30"), true},
+		{"across midnight after it belongs to previous day", "Fri 22:00-02:00", at(6, "01:30"), true},
+		{"across midnight after end", "Fri 22:00-02:00", at(6, "02:00"), false},
+		{"across midnight morning of its own day", "Fri 22:00-02:00", at(5, "01:30"), false},
+		{"across midnight evening of next day", "Fri 22:00-02:00", at(6, "23:30"), false},
+		{"across midnight from sunday to monday", "Sun 23:00-01:00", at(8, "00:30"), true},
+
+		{"start equal to end is whole day", "Sat 03:00-03:00", at(6, "12:00"), true},
+		{"start equal to end at midnight", "Sat 00:00-00:00", at(6, "23:59"), true},
+		{"start equal to end on other day", "Sat 03:00-03:00", at(7, "12:00"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := parseWindow(tt.window)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := w.contains(tt.t); got != tt.want {
+				t.Errorf("window %s contains %s = %v, want %v", tt.window, tt.t.Format(time.RFC1123), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseWindowErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"Mon-Fri",
+		"Mon-Fri 02:00",
+		"Mon-Fri 2am-4am",
+		"Mon-Fry 02:00-04:00",
+		"Funday 02:00-04:00",
+		"Mon 02:00-04:00 UTC",
+	}
+	for _, s := range tests {
+		_, err := parseWindow(s)
+		if err == nil {
+			t.Errorf("parseWindow(%q) did not fail", s)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// Build is an image plasma built from service's uploaded build context.
+type Build struct {
+	gorm.Model
+	Project string `gorm:"index"` // project's name, it may not exist yet when building
+	Service string // service's name from compose file
+	ImageID string
+	Image   string // unique tag of the image, used as service's image
+}
+
+func AddBuild(build *Build) error {
+	return DB.Create(build).Error
+}
+
+// LatestBuild returns the last image built for service of the project.
+func LatestBuild(projName string, svcName string) (*Build, error) {
+	var build Build
+	err := DB.Where("project = ? AND service = ?", projName, svcName).
+		Order("id desc").First(&build).Error
+	if err != nil {
+		return nil, err
+	}
+	return &build, nil
+}
+
+// GetBuild returns build of service of the project that produced given image.
+func GetBuild(projName string, svcName string, imageID string) (*Build, error) {
+	var build Build
+	err := DB.Where("project = ? AND service = ? AND image_id = ?", projName, svcName, imageID).
+		Order("id desc").First(&build).Error
+	if err != nil {
+		return nil, err
+	}
+	return &build, nil
+}
+
+// Builds returns all images built by plasma.
+func Builds() ([]Build, error) {
+	var builds []Build
+	err := DB.Find(&builds).Error
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return builds, nil
+}
@@ -1,9 +1,13 @@
 package db
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
-	"strings"
+	"slices"
 	"time"
 
 	"github.com/compose-spec/compose-go/v2/types"
@@ -13,6 +17,8 @@ import (
 
 var DB *gorm.DB
 
+var ErrNotFound = gorm.ErrRecordNotFound
+
 type Volume struct {
 	gorm.Model
 	Name      string
@@ -42,11 +48,244 @@ type Service struct {
 	Volumes                  *string // []VolumeInDB, marshalled as json string
 	Ports                    *string // []PortInDB, marshalled as json string
 	ControllerKillCount      uint
+	Paused                   bool    // controller leaves paused services alone
+	DesiredState             string  `gorm:"default:running"` // "running" or "stopped"
+	Replicas                 uint    `gorm:"default:1"`
+	Autoscale                *string // AutoscaleInDB, marshalled as json string
+	LastScaledAt             *time.Time
+	UpdateStrategy           string `gorm:"default:recreate"` // one of Update* consts
+	UpdateTimeout            *time.Duration
+	UpdateMonitor            *time.Duration
+	FailedSpecHash           *string // spec that failed to become healthy during update
+	AutoUpdate               *string // AutoUpdateInDB, marshalled as json string
+	ImageDigest              *string // repo digest containers are created from instead of image's tag
+	LastImageCheck           *time.Time
+	Platform                 *string // e.g. linux/arm64, image and containers are for server's platform if nil
+	PreviousSpec             *string // Service before apply not rolled out yet, as json, restored if update fails
+}
+
+// Update strategies of services, used when service's spec changes.
+const (
+	// stop old container, then start the new one
+	UpdateRecreate = "recreate"
+	// for every replica start new container, wait until it's healthy, then remove old one
+	UpdateRolling = "rolling"
+	// start new containers for all replicas, wait until they are healthy, then remove old ones
+	UpdateBlueGreen = "blue-green"
+)
+
+// SpecHash identifies configuration service's containers are created from,
+// when it changes containers need to be replaced.
+func (svc *Service) SpecHash() string {
+	fields := []any{
+		svc.Image,
+		svc.Command,
+		svc.Entrypoint,
+		svc.Environment,
+		svc.Expose,
+		svc.Hostname,
+		svc.HealthCheckCmd,
+		svc.HealthCheckTimeout,
+		svc.HealthCheckInterval,
+		svc.HealthCheckRetries,
+		svc.HealthCheckStartPeriod,
+		svc.HealthCheckStartInterval,
+		svc.HealthCheckDisable,
+		svc.Volumes,
+		svc.Ports,
+	}
+	// only when set, so hashes of services without them stay the same
+	if svc.ImageDigest != nil {
+		fields = append(fields, *svc.ImageDigest)
+	}
+	if svc.Platform != nil {
+		fields = append(fields, "platform="+*svc.Platform)
+	}
+	spec, _ := json.Marshal(fields)
+	sum := sha256.Sum256(spec)
+	return hex.EncodeToString(sum[:12])
+}
+
+// ImageRef returns what service's containers are created from,
+// image's digest if service is pinned to it, else the image itself.
+func (svc *Service) ImageRef() string {
+	if svc.ImageDigest != nil {
+		return *svc.ImageDigest
+	}
+	return svc.Image
+}
+
+// PublishesPorts reports if service binds fixed host ports,
+// in which case it can't run more than one replica.
+func (svc *Service) PublishesPorts() (bool, error) {
+	if svc.Ports == nil {
+		return false, nil
+	}
+	var ports []PortInDB
+	err := json.Unmarshal([]byte(*svc.Ports), &ports)
+	if err != nil {
+		return false, err
+	}
+	for _, port := range ports {
+		if port.Published != "" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ReconcileStatus is the outcome of the controller's last check of a service.
+type ReconcileStatus struct {
+	gorm.Model
+	ServiceId   uint `gorm:"uniqueIndex"`
+	LastCheck   time.Time
+	LastAction  string // "none", "started", "killed" or "skipped"
+	LastError   *string
+	ImageDigest *string
+	ContainerID *string
+	ExitCode    *int
 }
 
 type Project struct {
 	gorm.Model
-	Name string `gorm:"unique"`
+	Name         string `gorm:"unique"`
+	Paused       bool   // controller leaves all resources of paused project alone
+	DesiredState string `gorm:"default:running"` // "running" or "stopped"
+}
+
+const (
+	StateRunning = "running"
+	StateStopped = "stopped"
+)
+
+var ErrProjectStopped = errors.New("project is stopped")
+
+// Event is a record of a decision plasma made on its own, e.g. scaling.
+type Event struct {
+	gorm.Model
+	ProjectId uint
+	ServiceId uint
+	Kind      string
+	Message   string
+}
+
+// AutoscaleInDB is parsed from x-plasma-autoscale compose extension.
+type AutoscaleInDB struct {
+	Min          uint          `json:"min"`
+	Max          uint          `json:"max"`
+	CPU          float64       `json:"cpu"`    // target CPU usage per replica, 100 is one core
+	Memory       float64       `json:"memory"` // target memory usage per replica, percent of its limit
+	CooldownUp   time.Duration `json:"cooldown_up"`
+	CooldownDown time.Duration `json:"cooldown_down"`
+}
+
+type autoscaleExt struct {
+	Min          uint    `mapstructure:"min"`
+	Max          uint    `mapstructure:"max"`
+	CPU          float64 `mapstructure:"cpu"`
+	Memory       float64 `mapstructure:"memory"`
+	CooldownUp   string  `mapstructure:"cooldown_up"`
+	CooldownDown string  `mapstructure:"cooldown_down"`
+}
+
+const AutoscaleExtension = "x-plasma-autoscale"
+
+type updateExt struct {
+	Strategy      string `mapstructure:"strategy"`
+	HealthTimeout string `mapstructure:"health_timeout"`
+	Monitor       string `mapstructure:"monitor"`
+}
+
+const UpdateExtension = "x-plasma-update"
+
+// updateFromCompose sets update strategy of service from deploy.update_config,
+// where order 'start-first' means rolling update, or from x-plasma-update
+// extension which takes precedence.
+func updateFromCompose(svc types.ServiceConfig, newSvc *Service) error {
+	newSvc.UpdateStrategy = UpdateRecreate
+	if svc.Deploy != nil && svc.Deploy.UpdateConfig != nil {
+		if svc.Deploy.UpdateConfig.Order == "start-first" {
+			newSvc.UpdateStrategy = UpdateRolling
+		}
+		if svc.Deploy.UpdateConfig.Monitor != 0 {
+			monitor := time.Duration(svc.Deploy.UpdateConfig.Monitor)
+			newSvc.UpdateMonitor = &monitor
+		}
+	}
+	var ext updateExt
+	found, err := svc.Extensions.Get(UpdateExtension, &ext)
+	if err != nil {
+		return fmt.Errorf("service %s: %s: %w", svc.Name, UpdateExtension, err)
+	}
+	if !found {
+		return nil
+	}
+	switch ext.Strategy {
+	case "":
+	case UpdateRecreate, UpdateRolling, UpdateBlueGreen:
+		newSvc.UpdateStrategy = ext.Strategy
+	default:
+		return fmt.Errorf(
+			"service %s: %s: unknown strategy '%s', use %s, %s or %s",
+			svc.Name, UpdateExtension, ext.Strategy, UpdateRecreate, UpdateRolling, UpdateBlueGreen,
+		)
+	}
+	if ext.HealthTimeout != "" {
+		timeout, err := time.ParseDuration(ext.HealthTimeout)
+		if err != nil {
+			return fmt.Errorf("service %s: %s: health_timeout: %w", svc.Name, UpdateExtension, err)
+		}
+		newSvc.UpdateTimeout = &timeout
+	}
+	if ext.Monitor != "" {
+		monitor, err := time.ParseDuration(ext.Monitor)
+		if err != nil {
+			return fmt.Errorf("service %s: %s: monitor: %w", svc.Name, UpdateExtension, err)
+		}
+		newSvc.UpdateMonitor = &monitor
+	}
+	return nil
+}
+
+func autoscaleFromCompose(svc types.ServiceConfig) (*AutoscaleInDB, error) {
+	var ext autoscaleExt
+	found, err := svc.Extensions.Get(AutoscaleExtension, &ext)
+	if err != nil {
+		return nil, fmt.Errorf("service %s: %s: %w", svc.Name, AutoscaleExtension, err)
+	}
+	if !found {
+		return nil, nil
+	}
+	autoscale := AutoscaleInDB{
+		Min:          ext.Min,
+		Max:          ext.Max,
+		CPU:          ext.CPU,
+		Memory:       ext.Memory,
+		CooldownUp:   time.Minute,
+		CooldownDown: 5 * time.Minute,
+	}
+	if autoscale.Min == 0 {
+		autoscale.Min = 1
+	}
+	if autoscale.Max < autoscale.Min {
+		return nil, fmt.Errorf("service %s: %s: max must be at least min", svc.Name, AutoscaleExtension)
+	}
+	if autoscale.CPU <= 0 && autoscale.Memory <= 0 {
+		return nil, fmt.Errorf("service %s: %s: cpu or memory target is required", svc.Name, AutoscaleExtension)
+	}
+	if ext.CooldownUp != "" {
+		autoscale.CooldownUp, err = time.ParseDuration(ext.CooldownUp)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %s: cooldown_up: %w", svc.Name, AutoscaleExtension, err)
+		}
+	}
+	if ext.CooldownDown != "" {
+		autoscale.CooldownDown, err = time.ParseDuration(ext.CooldownDown)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %s: cooldown_down: %w", svc.Name, AutoscaleExtension, err)
+		}
+	}
+	return &autoscale, nil
 }
 
 type VolumeInDB struct {
@@ -90,11 +329,65 @@ func Init() error {
 		log.Println(err)
 		return err
 	}
+	log.Println("Migrating table reconcile_statuses...")
+	err = DB.AutoMigrate(&ReconcileStatus{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	log.Println("Migrating table events...")
+	err = DB.AutoMigrate(&Event{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	log.Println("Migrating table revisions...")
+	err = DB.AutoMigrate(&Revision{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	log.Println("Migrating table builds...")
+	err = DB.AutoMigrate(&Build{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	log.Println("Migrating table images...")
+	err = DB.AutoMigrate(&Image{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	log.Println("Migrating table replaced_images...")
+	err = DB.AutoMigrate(&ReplacedImage{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	log.Println("Migrating table registry_credentials...")
+	err = DB.AutoMigrate(&RegistryCredential{})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
 	log.Println("SQLite database automigrated.")
 	return nil
 }
 
-func servicesFromCompose(input *types.Project, projID uint) ([]*Service, error) {
+// Pinnable reports if service with pull policy can be pinned to image digest.
+// Services pulling by policy, always or on refresh, follow their tag instead.
+func Pinnable(pullPolicy string) bool {
+	policy, _, err := types.ServiceConfig{PullPolicy: pullPolicy}.GetPullPolicy()
+	if err != nil {
+		return false
+	}
+	return policy != types.PullPolicyAlways && policy != types.PullPolicyRefresh
+}
+
+// servicesFromCompose converts compose services to db ones, pinning
+// them to digests of their images.
+func servicesFromCompose(input *types.Project, projID uint, digests map[string]string) ([]*Service, error) {
 	svcs := []*Service{}
 	for _, svc := range input.Services {
 		newSvc := Service{
@@ -117,6 +410,13 @@ func servicesFromCompose(input *types.Project, projID uint) ([]*Service, error)
 			HealthCheckDisable:       nil,
 			Image:                    svc.Image, // probably never empty
 			PullPolicy:               nil,
+			Replicas:                 1,
+		}
+		if scale := svc.GetScale(); scale > 0 {
+			newSvc.Replicas = uint(scale)
+		} else {
+			// scale: 0 means service is defined, but should not run
+			newSvc.DesiredState = StateStopped
 		}
 		if svc.Command != nil {
 			cmdBytes, err := json.Marshal(svc.Command)
@@ -137,6 +437,7 @@ func servicesFromCompose(input *types.Project, projID uint) ([]*Service, error)
 				depsKeys[i] = k
 				i++
 			}
+			slices.Sort(depsKeys)
 			depsBytes, err := json.Marshal(depsKeys)
 			if err != nil {
 				log.Println(err)
@@ -179,7 +480,12 @@ func servicesFromCompose(input *types.Project, projID uint) ([]*Service, error)
 		}
 		if svc.HealthCheck != nil {
 			if svc.HealthCheck.Test != nil {
-				healthcheckCmd := strings.Join(svc.HealthCheck.Test, " ")
+				testBytes, err := json.Marshal(svc.HealthCheck.Test)
+				if err != nil {
+					log.Println(err)
+					return nil, err
+				}
+				healthcheckCmd := string(testBytes)
 				newSvc.HealthCheckCmd = &healthcheckCmd
 			}
 			if svc.HealthCheck.Timeout != nil {
@@ -207,6 +513,9 @@ func servicesFromCompose(input *types.Project, projID uint) ([]*Service, error)
 		if svc.PullPolicy != "" {
 			newSvc.PullPolicy = &svc.PullPolicy
 		}
+		if svc.Platform != "" {
+			newSvc.Platform = &svc.Platform
+		}
 		if svc.Volumes != nil {
 			vols := []VolumeInDB{}
 			for _, v := range svc.Volumes {
@@ -234,6 +543,68 @@ func servicesFromCompose(input *types.Project, projID uint) ([]*Service, error)
 			volsToDB := string(volsBytes)
 			newSvc.Volumes = &volsToDB
 		}
+		if newSvc.Replicas > 1 {
+			publishes, err := newSvc.PublishesPorts()
+			if err != nil {
+				log.Println(err)
+				return nil, err
+			}
+			if publishes {
+				return nil, fmt.Errorf(
+					"service '%s' publishes host ports, it can't run more than one replica", svc.Name,
+				)
+			}
+		}
+		err := updateFromCompose(svc, &newSvc)
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		autoscale, err := autoscaleFromCompose(svc)
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		if autoscale != nil {
+			autoscaleBytes, err := json.Marshal(autoscale)
+			if err != nil {
+				log.Println(err)
+				return nil, err
+			}
+			autoscaleToDB := string(autoscaleBytes)
+			newSvc.Autoscale = &autoscaleToDB
+			newSvc.Replicas = min(max(newSvc.Replicas, autoscale.Min), autoscale.Max)
+			if newSvc.Replicas > 1 {
+				publishes, err := newSvc.PublishesPorts()
+				if err != nil {
+					log.Println(err)
+					return nil, err
+				}
+				if publishes {
+					return nil, fmt.Errorf(
+						"service '%s' publishes host ports, autoscaling min can't be more than one replica",
+						svc.Name,
+					)
+				}
+			}
+		}
+		if digest, ok := digests[svc.Image]; ok && svc.Build == nil && Pinnable(svc.PullPolicy) {
+			newSvc.ImageDigest = &digest
+		}
+		autoUpdate, err := autoUpdateFromCompose(svc)
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		if autoUpdate != nil {
+			autoUpdateBytes, err := json.Marshal(autoUpdate)
+			if err != nil {
+				log.Println(err)
+				return nil, err
+			}
+			autoUpdateToDB := string(autoUpdateBytes)
+			newSvc.AutoUpdate = &autoUpdateToDB
+		}
 		if svc.Ports != nil {
 			var ports []PortInDB
 			for _, port := range svc.Ports {
@@ -271,8 +642,8 @@ func volumesFromCompose(input *types.Project, projID uint) ([]*Volume, error) {
 	return vols, nil
 }
 
-func splitCompose(input *types.Project, proj *Project) ([]*Service, []*Volume, error) {
-	svcs, err := servicesFromCompose(input, proj.ID)
+func splitCompose(input *types.Project, proj *Project, digests map[string]string) ([]*Service, []*Volume, error) {
+	svcs, err := servicesFromCompose(input, proj.ID, digests)
 	if err != nil {
 		log.Println(err)
 		return nil, nil, err
@@ -286,7 +657,7 @@ func splitCompose(input *types.Project, proj *Project) ([]*Service, []*Volume, e
 	return svcs, vols, nil
 }
 
-func NewProjectToDB(input *types.Project) error {
+func NewProjectToDB(input *types.Project, rev *Revision) error {
 	err := DB.Transaction(func(tx *gorm.DB) error {
 		if err := tx.Create(&Project{Name: input.Name}).Error; err != nil {
 			return err
@@ -297,7 +668,16 @@ func NewProjectToDB(input *types.Project) error {
 			log.Println(err)
 			return err
 		}
-		svcs, vols, err := splitCompose(input, proj)
+		digests, err := rev.ParseDigests()
+		if err != nil {
+			return err
+		}
+		svcs, vols, err := splitCompose(input, proj, digests)
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+		err = addRevision(tx, proj, svcs, vols, rev)
 		if err != nil {
 			log.Println(err)
 			return err
@@ -317,6 +697,240 @@ func NewProjectToDB(input *types.Project) error {
 	return err
 }
 
+func GetProject(name string) (*Project, error) {
+	var proj Project
+	err := DB.Where("name = ?", name).First(&proj).Error
+	if err != nil {
+		return nil, err
+	}
+	return &proj, nil
+}
+
+// ApplyProjectToDB updates existing project to match compose file.
+// Services and volumes missing from it are deleted and returned,
+// so their containers can be removed. Docker volumes are kept.
+func ApplyProjectToDB(input *types.Project, rev *Revision) ([]Service, error) {
+	var removed []Service
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var proj Project
+		err := tx.Where("name = ?", input.Name).First(&proj).Error
+		if err != nil {
+			return err
+		}
+		digests, err := rev.ParseDigests()
+		if err != nil {
+			return err
+		}
+		svcs, vols, err := splitCompose(input, &proj, digests)
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+		err = addRevision(tx, &proj, svcs, vols, rev)
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+		var oldSvcs []Service
+		err = tx.Where("project_id = ?", proj.ID).Find(&oldSvcs).Error
+		if err != nil {
+			return err
+		}
+		var oldVols []Volume
+		err = tx.Where("project_id = ?", proj.ID).Find(&oldVols).Error
+		if err != nil {
+			return err
+		}
+		for _, svc := range svcs {
+			i := slices.IndexFunc(oldSvcs, func(old Service) bool { return old.Name == svc.Name })
+			if i == -1 {
+				if err := tx.Create(svc).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			keepState(svc, &oldSvcs[i])
+			if err := tx.Save(svc).Error; err != nil {
+				return err
+			}
+		}
+		for _, old := range oldSvcs {
+			if !slices.ContainsFunc(svcs, func(svc *Service) bool { return svc.Name == old.Name }) {
+				if err := tx.Delete(&old).Error; err != nil {
+					return err
+				}
+				removed = append(removed, old)
+			}
+		}
+		for _, vol := range vols {
+			if !slices.ContainsFunc(oldVols, func(old Volume) bool { return old.Name == vol.Name }) {
+				if err := tx.Create(vol).Error; err != nil {
+					return err
+				}
+			}
+		}
+		for _, old := range oldVols {
+			if !slices.ContainsFunc(vols, func(vol *Volume) bool { return vol.Name == old.Name }) {
+				if err := tx.Delete(&old).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// keepState copies what plasma itself knows about the service
+// from its current version in db.
+func keepState(svc *Service, old *Service) {
+	svc.ID = old.ID
+	svc.CreatedAt = old.CreatedAt
+	svc.ControllerKillCount = old.ControllerKillCount
+	svc.Paused = old.Paused
+	svc.FailedSpecHash = old.FailedSpecHash
+	svc.LastScaledAt = old.LastScaledAt
+	svc.LastImageCheck = old.LastImageCheck
+	// image which could not be resolved stays pinned to the digest it had
+	var policy string
+	if svc.PullPolicy != nil {
+		policy = *svc.PullPolicy
+	}
+	if svc.ImageDigest == nil && svc.Image == old.Image && Pinnable(policy) {
+		svc.ImageDigest = old.ImageDigest
+	}
+	if svc.DesiredState == "" {
+		svc.DesiredState = old.DesiredState
+	}
+	if svc.Autoscale != nil {
+		svc.Replicas = old.Replicas
+	}
+	// containers still run spec from before apply which was not rolled out yet
+	svc.PreviousSpec = old.PreviousSpec
+	if svc.PreviousSpec == nil && svc.SpecHash() != old.SpecHash() {
+		prev, err := json.Marshal(old)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		prevSpec := string(prev)
+		svc.PreviousSpec = &prevSpec
+	}
+}
+
+// RestorePreviousSpec puts back spec service had before apply whose update
+// was rolled back, so replaced containers are created from it again, keeping
+// what plasma itself knows about the service. It's restored only once.
+func RestorePreviousSpec(svc *Service) error {
+	if svc.PreviousSpec == nil {
+		return nil
+	}
+	var prev Service
+	err := json.Unmarshal([]byte(*svc.PreviousSpec), &prev)
+	if err != nil {
+		return err
+	}
+	// not keepState, which could pin previous spec to current digest
+	prev.ID = svc.ID
+	prev.CreatedAt = svc.CreatedAt
+	prev.ProjectId = svc.ProjectId
+	prev.ControllerKillCount = svc.ControllerKillCount
+	prev.Paused = svc.Paused
+	prev.FailedSpecHash = svc.FailedSpecHash
+	prev.LastScaledAt = svc.LastScaledAt
+	prev.LastImageCheck = svc.LastImageCheck
+	prev.DesiredState = svc.DesiredState
+	prev.Replicas = svc.Replicas
+	prev.PreviousSpec = nil
+	err = DB.Save(&prev).Error
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	*svc = prev
+	return nil
+}
+
+// ClearPreviousSpec forgets spec from before apply, once containers
+// run the current one.
+func ClearPreviousSpec(svc *Service) error {
+	svc.PreviousSpec = nil
+	return DB.Model(&Service{}).Where("id = ?", svc.ID).Update("previous_spec", nil).Error
+}
+
+// PlanProject returns changes applying compose file would make to the project,
+// with images pinned to given digests, without saving anything. Services
+// whose containers would be replaced are marked as "recreate".
+func PlanProject(input *types.Project, digests map[string]string) ([]Change, error) {
+	proj, err := GetProject(input.Name)
+	if errors.Is(err, ErrNotFound) {
+		proj = &Project{Name: input.Name}
+	} else if err != nil {
+		return nil, err
+	}
+	svcs, vols, err := splitCompose(input, proj, digests)
+	if err != nil {
+		return nil, err
+	}
+	current := &RevisionSpec{}
+	if proj.ID != 0 {
+		err = DB.Where("project_id = ?", proj.ID).Find(&current.Services).Error
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		err = DB.Where("project_id = ?", proj.ID).Find(&current.Volumes).Error
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+	}
+	for _, svc := range svcs {
+		i := slices.IndexFunc(current.Services, func(old *Service) bool { return old.Name == svc.Name })
+		if i != -1 {
+			keepState(svc, current.Services[i])
+		}
+	}
+	changes, err := DiffSpec(current, &RevisionSpec{Services: svcs, Volumes: vols})
+	if err != nil {
+		return nil, err
+	}
+	for i, change := range changes {
+		if change.Kind != "service" || change.Action != "update" {
+			continue
+		}
+		old := current.Services[slices.IndexFunc(current.Services, func(s *Service) bool { return s.Name == change.Name })]
+		new := svcs[slices.IndexFunc(svcs, func(s *Service) bool { return s.Name == change.Name })]
+		if old.SpecHash() != new.SpecHash() {
+			changes[i].Action = "recreate"
+		}
+	}
+	return changes, nil
+}
+
+func SetFailedSpecHash(svc *Service, hash *string) error {
+	return DB.Model(&Service{}).Where("id = ?", svc.ID).Update("failed_spec_hash", hash).Error
+}
+
+// SetImageDigest pins service to image digest, or unpins it if digest is nil.
+func SetImageDigest(svc *Service, digest *string) error {
+	return DB.Model(&Service{}).Where("id = ?", svc.ID).Update("image_digest", digest).Error
+}
+
+func SetLastImageCheck(svc *Service, t time.Time) error {
+	return DB.Model(&Service{}).Where("id = ?", svc.ID).Update("last_image_check", t).Error
+}
+
+func ProjectName(id uint) (string, error) {
+	var proj Project
+	err := DB.Select("id", "name").First(&proj, id).Error
+	if err != nil {
+		log.Println(err)
+		return "", err
+	}
+	return proj.Name, nil
+}
+
 func UpKillCount(svc *Service) error {
 	err := DB.Transaction(func(tx *gorm.DB) error {
 		var svcFromDB *Service
@@ -334,10 +948,229 @@ func UpKillCount(svc *Service) error {
 	return err
 }
 
-func Ps() ([]Project, []Service, []Volume, error) {
+// SetPaused pauses or unpauses given services of the project,
+// or the whole project if svcNames is empty.
+func SetPaused(projName string, svcNames []string, paused bool) error {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var proj Project
+		err := tx.Where("name = ?", projName).First(&proj).Error
+		if err != nil {
+			return err
+		}
+		if len(svcNames) == 0 {
+			return tx.Model(&proj).Update("paused", paused).Error
+		}
+		for _, svcName := range svcNames {
+			res := tx.Model(&Service{}).
+				Where("project_id = ? AND name = ?", proj.ID, projName+"_"+svcName).
+				Update("paused", paused)
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				return fmt.Errorf("service '%s' not found in project '%s': %w", svcName, projName, ErrNotFound)
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// SetDesiredState sets desired state of given services of the project,
+// or of the project if svcNames is empty. Starting a project starts all of
+// its services, services of a stopped project can't be started on their own.
+func SetDesiredState(projName string, svcNames []string, state string) error {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var proj Project
+		err := tx.Where("name = ?", projName).First(&proj).Error
+		if err != nil {
+			return err
+		}
+		if len(svcNames) == 0 {
+			err := tx.Model(&proj).Update("desired_state", state).Error
+			if err != nil {
+				return err
+			}
+			if state == StateRunning {
+				return tx.Model(&Service{}).
+					Where("project_id = ?", proj.ID).
+					Update("desired_state", state).Error
+			}
+			return nil
+		}
+		if state == StateRunning && proj.DesiredState == StateStopped {
+			return fmt.Errorf("%w, start project '%s' first", ErrProjectStopped, projName)
+		}
+		for _, svcName := range svcNames {
+			res := tx.Model(&Service{}).
+				Where("project_id = ? AND name = ?", proj.ID, projName+"_"+svcName).
+				Update("desired_state", state)
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				return fmt.Errorf("service '%s' not found in project '%s': %w", svcName, projName, ErrNotFound)
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+func SetReplicas(projName string, svcName string, replicas uint) error {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var proj Project
+		err := tx.Where("name = ?", projName).First(&proj).Error
+		if err != nil {
+			return err
+		}
+		// autoscaler waits for cooldown before overriding it
+		res := tx.Model(&Service{}).
+			Where("project_id = ? AND name = ?", proj.ID, projName+"_"+svcName).
+			Updates(map[string]any{
+				"replicas":       replicas,
+				"last_scaled_at": time.Now(),
+			})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return fmt.Errorf("service '%s' not found in project '%s': %w", svcName, projName, ErrNotFound)
+		}
+		return nil
+	})
+	return err
+}
+
+// ScaleTo sets service's replicas as decided by autoscaler
+// and records the decision as an event.
+func ScaleTo(svc *Service, replicas uint, reason string) error {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := tx.Model(&Service{}).Where("id = ?", svc.ID).Updates(map[string]any{
+			"replicas":       replicas,
+			"last_scaled_at": now,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&Event{
+			ProjectId: svc.ProjectId,
+			ServiceId: svc.ID,
+			Kind:      "autoscale",
+			Message: fmt.Sprintf(
+				"scaled %s from %v to %v replicas: %s",
+				svc.Name, svc.Replicas, replicas, reason,
+			),
+		}).Error
+	})
+	return err
+}
+
+func AddEvent(event *Event) error {
+	return DB.Create(event).Error
+}
+
+// Events returns events of the project, or of all projects
+// if projName is empty, newest first.
+func Events(projName string, limit int) ([]Event, error) {
+	var events []Event
+	query := DB.Order("id desc").Limit(limit)
+	if projName != "" {
+		proj, err := GetProject(projName)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("project_id = ?", proj.ID)
+	}
+	err := query.Find(&events).Error
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return events, nil
+}
+
+// SaveReconcileStatus creates or replaces status of the service.
+func SaveReconcileStatus(status *ReconcileStatus) error {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var existing ReconcileStatus
+		err := tx.Where("service_id = ?", status.ServiceId).Limit(1).Find(&existing).Error
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+		status.ID = existing.ID
+		status.CreatedAt = existing.CreatedAt
+		return tx.Save(status).Error
+	})
+	return err
+}
+
+func GetService(projName string, svcName string) (*Service, *ReconcileStatus, error) {
+	var proj Project
+	var svc Service
+	var status *ReconcileStatus
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("name = ?", projName).First(&proj).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("project_id = ? AND name = ?", proj.ID, projName+"_"+svcName).
+			First(&svc).Error
+		if err != nil {
+			return err
+		}
+		var statuses []ReconcileStatus
+		err = tx.Where("service_id = ?", svc.ID).Limit(1).Find(&statuses).Error
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+		if len(statuses) > 0 {
+			status = &statuses[0]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &svc, status, nil
+}
+
+// ProjectResources returns the project with its services and volumes.
+func ProjectResources(projName string) (*Project, []Service, []Volume, error) {
+	var proj Project
+	var services []Service
+	var volumes []Volume
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("name = ?", projName).First(&proj).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("project_id = ?", proj.ID).Order("name").Find(&services).Error
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+		err = tx.Where("project_id = ?", proj.ID).Order("name").Find(&volumes).Error
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return &proj, services, volumes, nil
+}
+
+func Ps() ([]Project, []Service, []Volume, []ReconcileStatus, error) {
 	var projects []Project
 	var services []Service
 	var volumes []Volume
+	var statuses []ReconcileStatus
 	err := DB.Transaction(func(tx *gorm.DB) error {
 		err := tx.Find(&projects).Error
 		if err != nil {
@@ -354,7 +1187,12 @@ func Ps() ([]Project, []Service, []Volume, error) {
 			log.Println(err)
 			return err
 		}
+		err = tx.Find(&statuses).Error
+		if err != nil {
+			log.Println(err)
+			return err
+		}
 		return nil
 	})
-	return projects, services, volumes, err
+	return projects, services, volumes, statuses, err
 }
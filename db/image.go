@@ -0,0 +1,122 @@
+package db
+
+import (
+	"errors"
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// Sources of images plasma knows about. Pushed and built images
+// can't be pulled from any registry.
+const (
+	ImageSourcePull  = "pull"
+	ImageSourcePush  = "push" // pushed with 'plasma image push'
+	ImageSourceBuild = "build"
+)
+
+// Image is an image plasma put into docker itself.
+type Image struct {
+	gorm.Model
+	Ref     string `gorm:"uniqueIndex"`
+	ImageID string
+	Source  string // UpdatedAt of pulled image is when it was last pulled
+}
+
+// ReplacedImage is an image plasma pulled for a ref which now points to
+// another one, e.g. after tag moved, kept so it can be pruned once dangling.
+type ReplacedImage struct {
+	gorm.Model
+	Ref     string
+	ImageID string `gorm:"uniqueIndex"`
+}
+
+// SaveImage records image, replacing what was known about its ref.
+// Pulled image it replaces is remembered as ReplacedImage.
+func SaveImage(img *Image) error {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var existing Image
+		err := tx.Where("ref = ?", img.Ref).Limit(1).Find(&existing).Error
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+		if existing.ID != 0 && existing.Source == ImageSourcePull && existing.ImageID != img.ImageID {
+			err := tx.Where(ReplacedImage{ImageID: existing.ImageID}).
+				Assign(ReplacedImage{Ref: existing.Ref}).
+				FirstOrCreate(&ReplacedImage{}).Error
+			if err != nil {
+				log.Println(err)
+				return err
+			}
+		}
+		img.ID = existing.ID
+		img.CreatedAt = existing.CreatedAt
+		return tx.Save(img).Error
+	})
+	return err
+}
+
+// GetImage returns what plasma knows about image ref, or nil if nothing.
+func GetImage(ref string) (*Image, error) {
+	var img Image
+	err := DB.Where("ref = ?", ref).First(&img).Error
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &img, nil
+}
+
+// Images returns all images plasma knows about.
+func Images() ([]Image, error) {
+	var imgs []Image
+	err := DB.Order("ref").Find(&imgs).Error
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return imgs, nil
+}
+
+// DeleteImage forgets image ref, after it was removed from docker.
+func DeleteImage(ref string) error {
+	err := DB.Unscoped().Where("ref = ?", ref).Delete(&Image{}).Error
+	if err != nil {
+		log.Println(err)
+	}
+	return err
+}
+
+// PulledImageIDs returns IDs of all images plasma pulled,
+// current ones of pulled refs and ones they replaced.
+func PulledImageIDs() (map[string]bool, error) {
+	var current []string
+	err := DB.Model(&Image{}).Where("source = ?", ImageSourcePull).Pluck("image_id", &current).Error
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	var replaced []string
+	err = DB.Model(&ReplacedImage{}).Pluck("image_id", &replaced).Error
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	ids := map[string]bool{}
+	for _, id := range append(current, replaced...) {
+		ids[id] = true
+	}
+	return ids, nil
+}
+
+// ForgetReplacedImage forgets replaced image, after it was removed from docker.
+func ForgetReplacedImage(imageID string) error {
+	err := DB.Unscoped().Where("image_id = ?", imageID).Delete(&ReplacedImage{}).Error
+	if err != nil {
+		log.Println(err)
+	}
+	return err
+}
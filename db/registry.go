@@ -0,0 +1,205 @@
+package db
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log"
+	"os"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// RegistryCredential is login to a registry, used for all projects
+// or only for one of them. Password is stored encrypted.
+type RegistryCredential struct {
+	gorm.Model
+	Host      string `gorm:"uniqueIndex:idx_registry_project"`
+	ProjectId uint   `gorm:"uniqueIndex:idx_registry_project"` // 0 for all projects
+	Username  string
+	Password  string `json:"-"` // AES-GCM encrypted, base64 encoded
+}
+
+// file with key generated by older versions when PLASMA_SECRET_KEY was not
+// set, only used to decrypt credentials stored back then
+const secretKeyFile = "plasma.key"
+
+var ErrNoSecretKey = errors.New("PLASMA_SECRET_KEY is not set, refusing to store registry credentials")
+
+var (
+	secretKeyOnce sync.Once
+	secretKey     []byte
+	secretKeyErr  error
+)
+
+// loadSecretKey returns key credentials are encrypted with, derived
+// from PLASMA_SECRET_KEY. Key kept next to database by older versions
+// is used if it's not set, so credentials they stored can be read.
+func loadSecretKey() ([]byte, error) {
+	secretKeyOnce.Do(func() {
+		if k := os.Getenv("PLASMA_SECRET_KEY"); k != "" {
+			sum := sha256.Sum256([]byte(k))
+			secretKey = sum[:]
+			return
+		}
+		key, err := os.ReadFile(secretKeyFile)
+		if errors.Is(err, os.ErrNotExist) {
+			secretKeyErr = ErrNoSecretKey
+			return
+		}
+		if err != nil {
+			secretKeyErr = err
+			return
+		}
+		log.Println(
+			"WARNING: PLASMA_SECRET_KEY is not set, registry credentials are decrypted with key from",
+			secretKeyFile, "which is stored next to database, set PLASMA_SECRET_KEY and log in again.",
+		)
+		secretKey = key
+	})
+	return secretKey, secretKeyErr
+}
+
+func newGCM() (cipher.AEAD, error) {
+	key, err := loadSecretKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func encrypt(plain string) (string, error) {
+	// key kept next to database does not protect it
+	if os.Getenv("PLASMA_SECRET_KEY") == "" {
+		return "", ErrNoSecretKey
+	}
+	gcm, err := newGCM()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plain), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func decrypt(encrypted string) (string, error) {
+	gcm, err := newGCM()
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("encrypted value is too short")
+	}
+	nonce, sealed := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", errors.New("can't decrypt credentials, was PLASMA_SECRET_KEY changed?")
+	}
+	return string(plain), nil
+}
+
+// registryProjectId returns id of the project, or 0 for empty name.
+func registryProjectId(projName string) (uint, error) {
+	if projName == "" {
+		return 0, nil
+	}
+	proj, err := GetProject(projName)
+	if err != nil {
+		return 0, err
+	}
+	return proj.ID, nil
+}
+
+// SaveRegistryCredential stores login to registry host for the project,
+// or for all projects if projName is empty.
+func SaveRegistryCredential(host string, projName string, username string, password string) error {
+	projID, err := registryProjectId(projName)
+	if err != nil {
+		return err
+	}
+	encrypted, err := encrypt(password)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		var cred RegistryCredential
+		err := tx.Where("host = ? AND project_id = ?", host, projID).Limit(1).Find(&cred).Error
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+		cred.Host = host
+		cred.ProjectId = projID
+		cred.Username = username
+		cred.Password = encrypted
+		return tx.Save(&cred).Error
+	})
+	return err
+}
+
+// DeleteRegistryCredential removes login to registry host of the project,
+// or the one for all projects if projName is empty.
+func DeleteRegistryCredential(host string, projName string) error {
+	projID, err := registryProjectId(projName)
+	if err != nil {
+		return err
+	}
+	res := DB.Unscoped().Where("host = ? AND project_id = ?", host, projID).Delete(&RegistryCredential{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RegistryCredentials lists stored logins, without passwords.
+func RegistryCredentials() ([]RegistryCredential, error) {
+	var creds []RegistryCredential
+	err := DB.Order("host, project_id").Find(&creds).Error
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return creds, nil
+}
+
+// RegistryLogin returns username and password for registry host, preferring
+// the project's own login over the one for all projects. Empty username
+// means there is no login for the host.
+func RegistryLogin(host string, projID uint) (string, string, error) {
+	var creds []RegistryCredential
+	err := DB.Where("host = ? AND project_id IN ?", host, []uint{0, projID}).
+		Order("project_id desc").Limit(1).Find(&creds).Error
+	if err != nil {
+		log.Println(err)
+		return "", "", err
+	}
+	if len(creds) == 0 {
+		return "", "", nil
+	}
+	password, err := decrypt(creds[0].Password)
+	if err != nil {
+		log.Println(err)
+		return "", "", err
+	}
+	return creds[0].Username, password, nil
+}
@@ -0,0 +1,256 @@
+package db
+
+import (
+	"encoding/json"
+	"log"
+	"slices"
+
+	"gorm.io/gorm"
+)
+
+// Revision is an immutable snapshot of project made on every create,
+// apply or rollback.
+type Revision struct {
+	gorm.Model
+	ProjectId uint   `gorm:"uniqueIndex:idx_project_revision"`
+	Number    uint   `gorm:"uniqueIndex:idx_project_revision"`
+	Compose   string // original compose file
+	Spec      string // RevisionSpec, marshalled as json string
+	Digests   string // map of image to digest its services are pinned to, marshalled as json string
+	Builds    string // map of service to id of image built for it, marshalled as json string
+	Author    string
+	Source    string // "create", "apply", "update-images" or "rollback to <n>"
+}
+
+// RevisionSpec is project resolved from compose file.
+type RevisionSpec struct {
+	Services []*Service `json:"services"`
+	Volumes  []*Volume  `json:"volumes"`
+}
+
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+type Change struct {
+	Kind   string        `json:"kind"`   // "service", "volume" or "network"
+	Name   string        `json:"name"`   // full name, prefixed with project's name
+	Action string        `json:"action"` // "create", "update", "recreate" or "remove"
+	Fields []FieldChange `json:"fields"`
+}
+
+// fields that are not part of service's spec
+var ignoredFields = []string{
+	"ID",
+	"CreatedAt",
+	"UpdatedAt",
+	"DeletedAt",
+	"ProjectId",
+	"ControllerKillCount",
+	"Paused",
+	"LastScaledAt",
+	"FailedSpecHash",
+	"LastImageCheck",
+	"PreviousSpec",
+}
+
+// addRevision stores spec of services and volumes before they are saved,
+// so it does not contain ids, as next revision of the project.
+func addRevision(tx *gorm.DB, proj *Project, svcs []*Service, vols []*Volume, rev *Revision) error {
+	spec, err := json.Marshal(RevisionSpec{Services: svcs, Volumes: vols})
+	if err != nil {
+		return err
+	}
+	var last Revision
+	err = tx.Where("project_id = ?", proj.ID).Order("number desc").Limit(1).Find(&last).Error
+	if err != nil {
+		return err
+	}
+	rev.ProjectId = proj.ID
+	rev.Number = last.Number + 1
+	rev.Spec = string(spec)
+	if rev.Digests == "" {
+		rev.Digests = "{}"
+	}
+	if rev.Builds == "" {
+		rev.Builds = "{}"
+	}
+	return tx.Create(rev).Error
+}
+
+func Revisions(projName string) ([]Revision, error) {
+	proj, err := GetProject(projName)
+	if err != nil {
+		return nil, err
+	}
+	var revs []Revision
+	err = DB.Where("project_id = ?", proj.ID).Order("number").Find(&revs).Error
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return revs, nil
+}
+
+func GetRevision(projName string, number uint) (*Revision, error) {
+	proj, err := GetProject(projName)
+	if err != nil {
+		return nil, err
+	}
+	var rev Revision
+	err = DB.Where("project_id = ? AND number = ?", proj.ID, number).First(&rev).Error
+	if err != nil {
+		return nil, err
+	}
+	return &rev, nil
+}
+
+// PinHistory returns digests every service was pinned to, newest first
+// and without repeats, at most keep of them per service. Current pins
+// come first, as auto-updates move them without a new revision.
+func PinHistory(keep int) (map[string][]string, error) {
+	history := map[string][]string{}
+	add := func(svc *Service) {
+		if svc.ImageDigest == nil {
+			return
+		}
+		pins := history[svc.Name]
+		if len(pins) < keep && !slices.Contains(pins, *svc.ImageDigest) {
+			history[svc.Name] = append(pins, *svc.ImageDigest)
+		}
+	}
+	var services []Service
+	err := DB.Find(&services).Error
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	for i := range services {
+		add(&services[i])
+	}
+	var revs []Revision
+	err = DB.Order("project_id, number desc").Find(&revs).Error
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	for _, rev := range revs {
+		spec, err := rev.ParseSpec()
+		if err != nil {
+			return nil, err
+		}
+		for _, svc := range spec.Services {
+			add(svc)
+		}
+	}
+	return history, nil
+}
+
+// ParseDigests returns map of image to digest services are pinned to.
+func (rev *Revision) ParseDigests() (map[string]string, error) {
+	digests := map[string]string{}
+	if rev.Digests == "" {
+		return digests, nil
+	}
+	err := json.Unmarshal([]byte(rev.Digests), &digests)
+	if err != nil {
+		return nil, err
+	}
+	return digests, nil
+}
+
+func (rev *Revision) ParseSpec() (*RevisionSpec, error) {
+	var spec RevisionSpec
+	err := json.Unmarshal([]byte(rev.Spec), &spec)
+	if err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
+
+// DiffSpec compares two specs of the project and returns what changed
+// between them, field by field for services.
+func DiffSpec(old *RevisionSpec, new *RevisionSpec) ([]Change, error) {
+	changes := []Change{}
+	for _, newSvc := range new.Services {
+		i := slices.IndexFunc(old.Services, func(s *Service) bool { return s.Name == newSvc.Name })
+		if i == -1 {
+			changes = append(changes, Change{Kind: "service", Name: newSvc.Name, Action: "create"})
+			continue
+		}
+		fields, err := diffFields(old.Services[i], newSvc)
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) > 0 {
+			changes = append(changes, Change{
+				Kind:   "service",
+				Name:   newSvc.Name,
+				Action: "update",
+				Fields: fields,
+			})
+		}
+	}
+	for _, oldSvc := range old.Services {
+		if !slices.ContainsFunc(new.Services, func(s *Service) bool { return s.Name == oldSvc.Name }) {
+			changes = append(changes, Change{Kind: "service", Name: oldSvc.Name, Action: "remove"})
+		}
+	}
+	for _, newVol := range new.Volumes {
+		if !slices.ContainsFunc(old.Volumes, func(v *Volume) bool { return v.Name == newVol.Name }) {
+			changes = append(changes, Change{Kind: "volume", Name: newVol.Name, Action: "create"})
+		}
+	}
+	for _, oldVol := range old.Volumes {
+		if !slices.ContainsFunc(new.Volumes, func(v *Volume) bool { return v.Name == oldVol.Name }) {
+			changes = append(changes, Change{Kind: "volume", Name: oldVol.Name, Action: "remove"})
+		}
+	}
+	return changes, nil
+}
+
+func diffFields(old *Service, new *Service) ([]FieldChange, error) {
+	oldFields, err := specFields(old)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := specFields(new)
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	for k := range newFields {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	fields := []FieldChange{}
+	for _, k := range keys {
+		if oldFields[k] != newFields[k] {
+			fields = append(fields, FieldChange{Field: k, Old: oldFields[k], New: newFields[k]})
+		}
+	}
+	return fields, nil
+}
+
+// specFields returns service's spec fields as json strings.
+func specFields(svc *Service) (map[string]string, error) {
+	b, err := json.Marshal(svc)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]json.RawMessage
+	err = json.Unmarshal(b, &raw)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]string{}
+	for k, v := range raw {
+		if slices.Contains(ignoredFields, k) {
+			continue
+		}
+		fields[k] = string(v)
+	}
+	return fields, nil
+}
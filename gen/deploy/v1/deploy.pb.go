@@ -0,0 +1,317 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.7
+// 	protoc        (unknown)
+// source: deploy/v1/deploy.proto
+
+package deployv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type EventKind int32
+
+const (
+	EventKind_EVENT_KIND_UNSPECIFIED EventKind = 0
+	EventKind_EVENT_KIND_INFO        EventKind = 1
+	EventKind_EVENT_KIND_PULL        EventKind = 2
+	EventKind_EVENT_KIND_CREATE      EventKind = 3
+	EventKind_EVENT_KIND_START       EventKind = 4
+	EventKind_EVENT_KIND_HEALTH      EventKind = 5
+	EventKind_EVENT_KIND_READY       EventKind = 6
+	EventKind_EVENT_KIND_FAILED      EventKind = 7
+)
+
+// Enum value maps for EventKind.
+var (
+	EventKind_name = map[int32]string{
+		0: "EVENT_KIND_UNSPECIFIED",
+		1: "EVENT_KIND_INFO",
+		2: "EVENT_KIND_PULL",
+		3: "EVENT_KIND_CREATE",
+		4: "EVENT_KIND_START",
+		5: "EVENT_KIND_HEALTH",
+		6: "EVENT_KIND_READY",
+		7: "EVENT_KIND_FAILED",
+	}
+	EventKind_value = map[string]int32{
+		"EVENT_KIND_UNSPECIFIED": 0,
+		"EVENT_KIND_INFO":        1,
+		"EVENT_KIND_PULL":        2,
+		"EVENT_KIND_CREATE":      3,
+		"EVENT_KIND_START":       4,
+		"EVENT_KIND_HEALTH":      5,
+		"EVENT_KIND_READY":       6,
+		"EVENT_KIND_FAILED":      7,
+	}
+)
+
+func (x EventKind) Enum() *EventKind {
+	p := new(EventKind)
+	*p = x
+	return p
+}
+
+func (x EventKind) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (EventKind) Descriptor() protoreflect.EnumDescriptor {
+	return file_deploy_v1_deploy_proto_enumTypes[0].Descriptor()
+}
+
+func (EventKind) Type() protoreflect.EnumType {
+	return &file_deploy_v1_deploy_proto_enumTypes[0]
+}
+
+func (x EventKind) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use EventKind.Descriptor instead.
+func (EventKind) EnumDescriptor() ([]byte, []int) {
+	return file_deploy_v1_deploy_proto_rawDescGZIP(), []int{0}
+}
+
+type DeployRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Project       string                 `protobuf:"bytes,1,opt,name=project,proto3" json:"project,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeployRequest) Reset() {
+	*x = DeployRequest{}
+	mi := &file_deploy_v1_deploy_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeployRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeployRequest) ProtoMessage() {}
+
+func (x *DeployRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_deploy_v1_deploy_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeployRequest.ProtoReflect.Descriptor instead.
+func (*DeployRequest) Descriptor() ([]byte, []int) {
+	return file_deploy_v1_deploy_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *DeployRequest) GetProject() string {
+	if x != nil {
+		return x.Project
+	}
+	return ""
+}
+
+type DeployResponse struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Service   string                 `protobuf:"bytes,1,opt,name=service,proto3" json:"service,omitempty"`
+	Container string                 `protobuf:"bytes,2,opt,name=container,proto3" json:"container,omitempty"`
+	Kind      EventKind              `protobuf:"varint,3,opt,name=kind,proto3,enum=deploy.v1.EventKind" json:"kind,omitempty"`
+	Message   string                 `protobuf:"bytes,4,opt,name=message,proto3" json:"message,omitempty"`
+	// pull progress of one image layer
+	Layer   string `protobuf:"bytes,5,opt,name=layer,proto3" json:"layer,omitempty"`
+	Current int64  `protobuf:"varint,6,opt,name=current,proto3" json:"current,omitempty"`
+	Total   int64  `protobuf:"varint,7,opt,name=total,proto3" json:"total,omitempty"`
+	// health after transition: starting, healthy, unhealthy or none
+	Health        string `protobuf:"bytes,8,opt,name=health,proto3" json:"health,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeployResponse) Reset() {
+	*x = DeployResponse{}
+	mi := &file_deploy_v1_deploy_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeployResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeployResponse) ProtoMessage() {}
+
+func (x *DeployResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_deploy_v1_deploy_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeployResponse.ProtoReflect.Descriptor instead.
+func (*DeployResponse) Descriptor() ([]byte, []int) {
+	return file_deploy_v1_deploy_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *DeployResponse) GetService() string {
+	if x != nil {
+		return x.Service
+	}
+	return ""
+}
+
+func (x *DeployResponse) GetContainer() string {
+	if x != nil {
+		return x.Container
+	}
+	return ""
+}
+
+func (x *DeployResponse) GetKind() EventKind {
+	if x != nil {
+		return x.Kind
+	}
+	return EventKind_EVENT_KIND_UNSPECIFIED
+}
+
+func (x *DeployResponse) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *DeployResponse) GetLayer() string {
+	if x != nil {
+		return x.Layer
+	}
+	return ""
+}
+
+func (x *DeployResponse) GetCurrent() int64 {
+	if x != nil {
+		return x.Current
+	}
+	return 0
+}
+
+func (x *DeployResponse) GetTotal() int64 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+func (x *DeployResponse) GetHealth() string {
+	if x != nil {
+		return x.Health
+	}
+	return ""
+}
+
+var File_deploy_v1_deploy_proto protoreflect.FileDescriptor
+
+const file_deploy_v1_deploy_proto_rawDesc = "" +
+	"\n" +
+	"\x16deploy/v1/deploy.proto\x12\tdeploy.v1\")\n" +
+	"\rDeployRequest\x12\x18\n" +
+	"\aproject\x18\x01 \x01(\tR\aproject\"\xea\x01\n" +
+	"\x0eDeployResponse\x12\x18\n" +
+	"\aservice\x18\x01 \x01(\tR\aservice\x12\x1c\n" +
+	"\tcontainer\x18\x02 \x01(\tR\tcontainer\x12(\n" +
+	"\x04kind\x18\x03 \x01(\x0e2\x14.deploy.v1.EventKindR\x04kind\x12\x18\n" +
+	"\amessage\x18\x04 \x01(\tR\amessage\x12\x14\n" +
+	"\x05layer\x18\x05 \x01(\tR\x05layer\x12\x18\n" +
+	"\acurrent\x18\x06 \x01(\x03R\acurrent\x12\x14\n" +
+	"\x05total\x18\a \x01(\x03R\x05total\x12\x16\n" +
+	"\x06health\x18\b \x01(\tR\x06health*\xc2\x01\n" +
+	"\tEventKind\x12\x1a\n" +
+	"\x16EVENT_KIND_UNSPECIFIED\x10\x00\x12\x13\n" +
+	"\x0fEVENT_KIND_INFO\x10\x01\x12\x13\n" +
+	"\x0fEVENT_KIND_PULL\x10\x02\x12\x15\n" +
+	"\x11EVENT_KIND_CREATE\x10\x03\x12\x14\n" +
+	"\x10EVENT_KIND_START\x10\x04\x12\x15\n" +
+	"\x11EVENT_KIND_HEALTH\x10\x05\x12\x14\n" +
+	"\x10EVENT_KIND_READY\x10\x06\x12\x15\n" +
+	"\x11EVENT_KIND_FAILED\x10\a2P\n" +
+	"\rDeployService\x12?\n" +
+	"\x06Deploy\x12\x18.deploy.v1.DeployRequest\x1a\x19.deploy.v1.DeployResponse0\x01B\x91\x01\n" +
+	"\rcom.deploy.v1B\vDeployProtoP\x01Z.github.com/pgulb/plasma/gen/deploy/v1;deployv1\xa2\x02\x03DXX\xaa\x02\tDeploy.V1\xca\x02\tDeploy\\V1\xe2\x02\x15Deploy\\V1\\GPBMetadata\xea\x02\n" +
+	"Deploy::V1b\x06proto3"
+
+var (
+	file_deploy_v1_deploy_proto_rawDescOnce sync.Once
+	file_deploy_v1_deploy_proto_rawDescData []byte
+)
+
+func file_deploy_v1_deploy_proto_rawDescGZIP() []byte {
+	file_deploy_v1_deploy_proto_rawDescOnce.Do(func() {
+		file_deploy_v1_deploy_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_deploy_v1_deploy_proto_rawDesc), len(file_deploy_v1_deploy_proto_rawDesc)))
+	})
+	return file_deploy_v1_deploy_proto_rawDescData
+}
+
+var file_deploy_v1_deploy_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_deploy_v1_deploy_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_deploy_v1_deploy_proto_goTypes = []any{
+	(EventKind)(0),         // 0: deploy.v1.EventKind
+	(*DeployRequest)(nil),  // 1: deploy.v1.DeployRequest
+	(*DeployResponse)(nil), // 2: deploy.v1.DeployResponse
+}
+var file_deploy_v1_deploy_proto_depIdxs = []int32{
+	0, // 0: deploy.v1.DeployResponse.kind:type_name -> deploy.v1.EventKind
+	1, // 1: deploy.v1.DeployService.Deploy:input_type -> deploy.v1.DeployRequest
+	2, // 2: deploy.v1.DeployService.Deploy:output_type -> deploy.v1.DeployResponse
+	2, // [2:3] is the sub-list for method output_type
+	1, // [1:2] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_deploy_v1_deploy_proto_init() }
+func file_deploy_v1_deploy_proto_init() {
+	if File_deploy_v1_deploy_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_deploy_v1_deploy_proto_rawDesc), len(file_deploy_v1_deploy_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_deploy_v1_deploy_proto_goTypes,
+		DependencyIndexes: file_deploy_v1_deploy_proto_depIdxs,
+		EnumInfos:         file_deploy_v1_deploy_proto_enumTypes,
+		MessageInfos:      file_deploy_v1_deploy_proto_msgTypes,
+	}.Build()
+	File_deploy_v1_deploy_proto = out.File
+	file_deploy_v1_deploy_proto_goTypes = nil
+	file_deploy_v1_deploy_proto_depIdxs = nil
+}
@@ -0,0 +1,112 @@
+// Code generated by protoc-gen-connect-go. DO NOT EDIT.
+//
+// Source: deploy/v1/deploy.proto
+
+package deployv1connect
+
+import (
+	connect "connectrpc.com/connect"
+	context "context"
+	errors "errors"
+	v1 "github.com/pgulb/plasma/gen/deploy/v1"
+	http "net/http"
+	strings "strings"
+)
+
+// This is a compile-time assertion to ensure that this generated file and the connect package are
+// compatible. If you get a compiler error that this constant is not defined, this code was
+// generated with a version of connect newer than the one compiled into your binary. You can fix the
+// problem by either regenerating this code with an older version of connect or updating the connect
+// version compiled into your binary.
+const _ = connect.IsAtLeastVersion1_13_0
+
+const (
+	// DeployServiceName is the fully-qualified name of the DeployService service.
+	DeployServiceName = "deploy.v1.DeployService"
+)
+
+// These constants are the fully-qualified names of the RPCs defined in this package. They're
+// exposed at runtime as Spec.Procedure and as the final two segments of the HTTP route.
+//
+// Note that these are different from the fully-qualified method names used by
+// google.golang.org/protobuf/reflect/protoreflect. To convert from these constants to
+// reflection-formatted method names, remove the leading slash and convert the remaining slash to a
+// period.
+const (
+	// DeployServiceDeployProcedure is the fully-qualified name of the DeployService's Deploy RPC.
+	DeployServiceDeployProcedure = "/deploy.v1.DeployService/Deploy"
+)
+
+// DeployServiceClient is a client for the deploy.v1.DeployService service.
+type DeployServiceClient interface {
+	// Deploy starts services of the project right away and streams progress
+	// until all of them are healthy, one fails or request deadline expires.
+	Deploy(context.Context, *connect.Request[v1.DeployRequest]) (*connect.ServerStreamForClient[v1.DeployResponse], error)
+}
+
+// NewDeployServiceClient constructs a client for the deploy.v1.DeployService service. By default,
+// it uses the Connect protocol with the binary Protobuf Codec, asks for gzipped responses, and
+// sends uncompressed requests. To use the gRPC or gRPC-Web protocols, supply the connect.WithGRPC()
+// or connect.WithGRPCWeb() options.
+//
+// The URL supplied here should be the base URL for the Connect or gRPC server (for example,
+// http://api.acme.com or https://acme.com/grpc).
+func NewDeployServiceClient(httpClient connect.HTTPClient, baseURL string, opts ...connect.ClientOption) DeployServiceClient {
+	baseURL = strings.TrimRight(baseURL, "/")
+	deployServiceMethods := v1.File_deploy_v1_deploy_proto.Services().ByName("DeployService").Methods()
+	return &deployServiceClient{
+		deploy: connect.NewClient[v1.DeployRequest, v1.DeployResponse](
+			httpClient,
+			baseURL+DeployServiceDeployProcedure,
+			connect.WithSchema(deployServiceMethods.ByName("Deploy")),
+			connect.WithClientOptions(opts...),
+		),
+	}
+}
+
+// deployServiceClient implements DeployServiceClient.
+type deployServiceClient struct {
+	deploy *connect.Client[v1.DeployRequest, v1.DeployResponse]
+}
+
+// Deploy calls deploy.v1.DeployService.Deploy.
+func (c *deployServiceClient) Deploy(ctx context.Context, req *connect.Request[v1.DeployRequest]) (*connect.ServerStreamForClient[v1.DeployResponse], error) {
+	return c.deploy.CallServerStream(ctx, req)
+}
+
+// DeployServiceHandler is an implementation of the deploy.v1.DeployService service.
+type DeployServiceHandler interface {
+	// Deploy starts services of the project right away and streams progress
+	// until all of them are healthy, one fails or request deadline expires.
+	Deploy(context.Context, *connect.Request[v1.DeployRequest], *connect.ServerStream[v1.DeployResponse]) error
+}
+
+// NewDeployServiceHandler builds an HTTP handler from the service implementation. It returns the
+// path on which to mount the handler and the handler itself.
+//
+// By default, handlers support the Connect, gRPC, and gRPC-Web protocols with the binary Protobuf
+// and JSON codecs. They also support gzip compression.
+func NewDeployServiceHandler(svc DeployServiceHandler, opts ...connect.HandlerOption) (string, http.Handler) {
+	deployServiceMethods := v1.File_deploy_v1_deploy_proto.Services().ByName("DeployService").Methods()
+	deployServiceDeployHandler := connect.NewServerStreamHandler(
+		DeployServiceDeployProcedure,
+		svc.Deploy,
+		connect.WithSchema(deployServiceMethods.ByName("Deploy")),
+		connect.WithHandlerOptions(opts...),
+	)
+	return "/deploy.v1.DeployService/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case DeployServiceDeployProcedure:
+			deployServiceDeployHandler.ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// UnimplementedDeployServiceHandler returns CodeUnimplemented from all methods.
+type UnimplementedDeployServiceHandler struct{}
+
+func (UnimplementedDeployServiceHandler) Deploy(context.Context, *connect.Request[v1.DeployRequest], *connect.ServerStream[v1.DeployResponse]) error {
+	return connect.NewError(connect.CodeUnimplemented, errors.New("deploy.v1.DeployService.Deploy is not implemented"))
+}
@@ -9,6 +9,7 @@ package logsv1
 import (
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
 	reflect "reflect"
 	sync "sync"
 	unsafe "unsafe"
@@ -21,9 +22,72 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+type Stream int32
+
+const (
+	Stream_STREAM_UNSPECIFIED Stream = 0
+	Stream_STREAM_STDOUT      Stream = 1
+	Stream_STREAM_STDERR      Stream = 2
+)
+
+// Enum value maps for Stream.
+var (
+	Stream_name = map[int32]string{
+		0: "STREAM_UNSPECIFIED",
+		1: "STREAM_STDOUT",
+		2: "STREAM_STDERR",
+	}
+	Stream_value = map[string]int32{
+		"STREAM_UNSPECIFIED": 0,
+		"STREAM_STDOUT":      1,
+		"STREAM_STDERR":      2,
+	}
+)
+
+func (x Stream) Enum() *Stream {
+	p := new(Stream)
+	*p = x
+	return p
+}
+
+func (x Stream) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Stream) Descriptor() protoreflect.EnumDescriptor {
+	return file_logs_v1_logs_proto_enumTypes[0].Descriptor()
+}
+
+func (Stream) Type() protoreflect.EnumType {
+	return &file_logs_v1_logs_proto_enumTypes[0]
+}
+
+func (x Stream) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Stream.Descriptor instead.
+func (Stream) EnumDescriptor() ([]byte, []int) {
+	return file_logs_v1_logs_proto_rawDescGZIP(), []int{0}
+}
+
 type LogStreamRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Name  string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// keep streaming new lines until client disconnects
+	Follow bool `protobuf:"varint,2,opt,name=follow,proto3" json:"follow,omitempty"`
+	// number of lines from the end, or "all" if empty
+	Tail string `protobuf:"bytes,3,opt,name=tail,proto3" json:"tail,omitempty"`
+	// RFC 3339 timestamp, unix timestamp or duration relative to now, e.g. 10m
+	Since string `protobuf:"bytes,4,opt,name=since,proto3" json:"since,omitempty"`
+	Until string `protobuf:"bytes,5,opt,name=until,proto3" json:"until,omitempty"`
+	// streams to show, both if none is set
+	Stdout bool `protobuf:"varint,7,opt,name=stdout,proto3" json:"stdout,omitempty"`
+	Stderr bool `protobuf:"varint,8,opt,name=stderr,proto3" json:"stderr,omitempty"`
+	// logs of all containers of project's services, or only given ones,
+	// merged in time order instead of one container's given by name
+	Project       string   `protobuf:"bytes,9,opt,name=project,proto3" json:"project,omitempty"`
+	Services      []string `protobuf:"bytes,10,rep,name=services,proto3" json:"services,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -65,9 +129,74 @@ func (x *LogStreamRequest) GetName() string {
 	return ""
 }
 
+func (x *LogStreamRequest) GetFollow() bool {
+	if x != nil {
+		return x.Follow
+	}
+	return false
+}
+
+func (x *LogStreamRequest) GetTail() string {
+	if x != nil {
+		return x.Tail
+	}
+	return ""
+}
+
+func (x *LogStreamRequest) GetSince() string {
+	if x != nil {
+		return x.Since
+	}
+	return ""
+}
+
+func (x *LogStreamRequest) GetUntil() string {
+	if x != nil {
+		return x.Until
+	}
+	return ""
+}
+
+func (x *LogStreamRequest) GetStdout() bool {
+	if x != nil {
+		return x.Stdout
+	}
+	return false
+}
+
+func (x *LogStreamRequest) GetStderr() bool {
+	if x != nil {
+		return x.Stderr
+	}
+	return false
+}
+
+func (x *LogStreamRequest) GetProject() string {
+	if x != nil {
+		return x.Project
+	}
+	return ""
+}
+
+func (x *LogStreamRequest) GetServices() []string {
+	if x != nil {
+		return x.Services
+	}
+	return nil
+}
+
+// LogStreamResponse is one line of container's logs, demultiplexed
+// from docker's stream, so lines split across frames are whole.
 type LogStreamResponse struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Message       []byte                 `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"`
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Timestamp *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	// containers with TTY have only stdout
+	Stream    Stream `protobuf:"varint,3,opt,name=stream,proto3,enum=logs.v1.Stream" json:"stream,omitempty"`
+	Container string `protobuf:"bytes,4,opt,name=container,proto3" json:"container,omitempty"`
+	// service the container is replica of, empty if plasma does not manage it
+	Service string `protobuf:"bytes,5,opt,name=service,proto3" json:"service,omitempty"`
+	// without trailing newline
+	Line          string `protobuf:"bytes,6,opt,name=line,proto3" json:"line,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -102,22 +231,68 @@ func (*LogStreamResponse) Descriptor() ([]byte, []int) {
 	return file_logs_v1_logs_proto_rawDescGZIP(), []int{1}
 }
 
-func (x *LogStreamResponse) GetMessage() []byte {
+func (x *LogStreamResponse) GetTimestamp() *timestamppb.Timestamp {
 	if x != nil {
-		return x.Message
+		return x.Timestamp
 	}
 	return nil
 }
 
+func (x *LogStreamResponse) GetStream() Stream {
+	if x != nil {
+		return x.Stream
+	}
+	return Stream_STREAM_UNSPECIFIED
+}
+
+func (x *LogStreamResponse) GetContainer() string {
+	if x != nil {
+		return x.Container
+	}
+	return ""
+}
+
+func (x *LogStreamResponse) GetService() string {
+	if x != nil {
+		return x.Service
+	}
+	return ""
+}
+
+func (x *LogStreamResponse) GetLine() string {
+	if x != nil {
+		return x.Line
+	}
+	return ""
+}
+
 var File_logs_v1_logs_proto protoreflect.FileDescriptor
 
 const file_logs_v1_logs_proto_rawDesc = "" +
 	"\n" +
-	"\x12logs/v1/logs.proto\x12\alogs.v1\"&\n" +
+	"\x12logs/v1/logs.proto\x12\alogs.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\xf6\x01\n" +
 	"\x10LogStreamRequest\x12\x12\n" +
-	"\x04name\x18\x01 \x01(\tR\x04name\"-\n" +
-	"\x11LogStreamResponse\x12\x18\n" +
-	"\amessage\x18\x01 \x01(\fR\amessage2U\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x16\n" +
+	"\x06follow\x18\x02 \x01(\bR\x06follow\x12\x12\n" +
+	"\x04tail\x18\x03 \x01(\tR\x04tail\x12\x14\n" +
+	"\x05since\x18\x04 \x01(\tR\x05since\x12\x14\n" +
+	"\x05until\x18\x05 \x01(\tR\x05until\x12\x16\n" +
+	"\x06stdout\x18\a \x01(\bR\x06stdout\x12\x16\n" +
+	"\x06stderr\x18\b \x01(\bR\x06stderr\x12\x18\n" +
+	"\aproject\x18\t \x01(\tR\aproject\x12\x1a\n" +
+	"\bservices\x18\n" +
+	" \x03(\tR\bservicesJ\x04\b\x06\x10\aR\n" +
+	"timestamps\"\xd1\x01\n" +
+	"\x11LogStreamResponse\x128\n" +
+	"\ttimestamp\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp\x12'\n" +
+	"\x06stream\x18\x03 \x01(\x0e2\x0f.logs.v1.StreamR\x06stream\x12\x1c\n" +
+	"\tcontainer\x18\x04 \x01(\tR\tcontainer\x12\x18\n" +
+	"\aservice\x18\x05 \x01(\tR\aservice\x12\x12\n" +
+	"\x04line\x18\x06 \x01(\tR\x04lineJ\x04\b\x01\x10\x02R\amessage*F\n" +
+	"\x06Stream\x12\x16\n" +
+	"\x12STREAM_UNSPECIFIED\x10\x00\x12\x11\n" +
+	"\rSTREAM_STDOUT\x10\x01\x12\x11\n" +
+	"\rSTREAM_STDERR\x10\x022U\n" +
 	"\rLoggerService\x12D\n" +
 	"\tLogStream\x12\x19.logs.v1.LogStreamRequest\x1a\x1a.logs.v1.LogStreamResponse0\x01B\x81\x01\n" +
 	"\vcom.logs.v1B\tLogsProtoP\x01Z*github.com/pgulb/plasma/gen/logs/v1;logsv1\xa2\x02\x03LXX\xaa\x02\aLogs.V1\xca\x02\aLogs\\V1\xe2\x02\x13Logs\\V1\\GPBMetadata\xea\x02\bLogs::V1b\x06proto3"
@@ -134,19 +309,24 @@ func file_logs_v1_logs_proto_rawDescGZIP() []byte {
 	return file_logs_v1_logs_proto_rawDescData
 }
 
+var file_logs_v1_logs_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
 var file_logs_v1_logs_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
 var file_logs_v1_logs_proto_goTypes = []any{
-	(*LogStreamRequest)(nil),  // 0: logs.v1.LogStreamRequest
-	(*LogStreamResponse)(nil), // 1: logs.v1.LogStreamResponse
+	(Stream)(0),                   // 0: logs.v1.Stream
+	(*LogStreamRequest)(nil),      // 1: logs.v1.LogStreamRequest
+	(*LogStreamResponse)(nil),     // 2: logs.v1.LogStreamResponse
+	(*timestamppb.Timestamp)(nil), // 3: google.protobuf.Timestamp
 }
 var file_logs_v1_logs_proto_depIdxs = []int32{
-	0, // 0: logs.v1.LoggerService.LogStream:input_type -> logs.v1.LogStreamRequest
-	1, // 1: logs.v1.LoggerService.LogStream:output_type -> logs.v1.LogStreamResponse
-	1, // [1:2] is the sub-list for method output_type
-	0, // [0:1] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	3, // 0: logs.v1.LogStreamResponse.timestamp:type_name -> google.protobuf.Timestamp
+	0, // 1: logs.v1.LogStreamResponse.stream:type_name -> logs.v1.Stream
+	1, // 2: logs.v1.LoggerService.LogStream:input_type -> logs.v1.LogStreamRequest
+	2, // 3: logs.v1.LoggerService.LogStream:output_type -> logs.v1.LogStreamResponse
+	3, // [3:4] is the sub-list for method output_type
+	2, // [2:3] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
 }
 
 func init() { file_logs_v1_logs_proto_init() }
@@ -159,13 +339,14 @@ func file_logs_v1_logs_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_logs_v1_logs_proto_rawDesc), len(file_logs_v1_logs_proto_rawDesc)),
-			NumEnums:      0,
+			NumEnums:      1,
 			NumMessages:   2,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
 		GoTypes:           file_logs_v1_logs_proto_goTypes,
 		DependencyIndexes: file_logs_v1_logs_proto_depIdxs,
+		EnumInfos:         file_logs_v1_logs_proto_enumTypes,
 		MessageInfos:      file_logs_v1_logs_proto_msgTypes,
 	}.Build()
 	File_logs_v1_logs_proto = out.File
@@ -1,81 +1,206 @@
 package grpcserver
 
 import (
-	"bufio"
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"slices"
+	"time"
 
 	connect "connectrpc.com/connect"
 	"connectrpc.com/grpcreflect"
 	"github.com/pgulb/plasma/container"
+	"github.com/pgulb/plasma/controller"
+	"github.com/pgulb/plasma/db"
+	deployv1 "github.com/pgulb/plasma/gen/deploy/v1"
+	"github.com/pgulb/plasma/gen/deploy/v1/deployv1connect"
 	logsv1 "github.com/pgulb/plasma/gen/logs/v1"
 	"github.com/pgulb/plasma/gen/logs/v1/logsv1connect"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 const address = "0.0.0.0:8081"
 
+// how long deploy waits for healthy services if client sets no deadline
+const defaultDeployTimeout = 5 * time.Minute
+
 type loggerServiceServer struct {
 	logsv1connect.UnimplementedLoggerServiceHandler
 }
 
+var logStreams = map[string]logsv1.Stream{
+	container.StreamStdout: logsv1.Stream_STREAM_STDOUT,
+	container.StreamStderr: logsv1.Stream_STREAM_STDERR,
+}
+
 func (s *loggerServiceServer) LogStream(
 	ctx context.Context,
 	req *connect.Request[logsv1.LogStreamRequest],
 	stream *connect.ServerStream[logsv1.LogStreamResponse],
 ) error {
+	// stops reading logs when client disconnects or sending fails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	name := req.Msg.GetName()
-	log.Printf("Got a request for logs from container %s", name)
+	projName := req.Msg.GetProject()
+	opts := container.LogOptions{
+		Follow: req.Msg.GetFollow(),
+		Tail:   req.Msg.GetTail(),
+		Since:  req.Msg.GetSince(),
+		Until:  req.Msg.GetUntil(),
+		Stdout: req.Msg.GetStdout(),
+		Stderr: req.Msg.GetStderr(),
+	}
 	c := make(chan container.LogResult, 1)
-	buffer := bytes.Buffer{}
-	scanner := bufio.NewScanner(&buffer)
-	go container.GoLogs(name, c)
+	if projName != "" {
+		name = "project " + projName
+		log.Printf("Got a request for logs from project %s", projName)
+		services, err := logServices(projName, req.Msg.GetServices())
+		if err != nil {
+			return err
+		}
+		go container.GoProjectLogs(ctx, projName, services, opts, c)
+	} else {
+		log.Printf("Got a request for logs from container %s", name)
+		go container.GoLogs(ctx, name, opts, c)
+	}
 	for result := range c {
-		// if channel returns error
+		if errors.Is(result.Err, container.ErrContainerNotFound) {
+			return connect.NewError(connect.CodeNotFound, result.Err)
+		}
 		if result.Err != nil {
-			var errWithMark []byte
-			errWithMark = fmt.Appendf(errWithMark, "........<grpc-error> %s\n", result.Err.Error())
-			if errSend := stream.Send(&logsv1.LogStreamResponse{
-				Message: errWithMark}); errSend != nil {
-				return errSend
-			}
+			return connect.NewError(connect.CodeInternal, result.Err)
 		}
-		// if channel returns log data
-		buffer.Write(result.Value)
-		// if log line did not end yet
-		if !bytes.Contains(result.Value, []byte{'\n'}) {
-			continue
+		entry := result.Entry
+		resp := &logsv1.LogStreamResponse{
+			Stream:    logStreams[entry.Stream],
+			Container: entry.Container,
+			Service:   entry.Service,
+			Line:      entry.Line,
 		}
-		if scanner.Scan() {
-			if err := stream.Send(&logsv1.LogStreamResponse{
-				Message: scanner.Bytes()}); err != nil {
-				return err
-			}
+		if !entry.Time.IsZero() {
+			resp.Timestamp = timestamppb.New(entry.Time)
 		}
-	}
-	// read rest of log lines if any
-	for scanner.Scan() {
-		log.Println("Sending some remaining log line from", name)
-		if err := stream.Send(&logsv1.LogStreamResponse{
-			Message: scanner.Bytes()}); err != nil {
+		if err := stream.Send(resp); err != nil {
 			return err
 		}
 	}
+	if ctx.Err() != nil {
+		log.Println("Client stopped reading logs from", name)
+		return ctx.Err()
+	}
 	log.Println("Done sending logs from", name)
 	return nil
 }
 
+// logServices returns full names of project's services given by their
+// names in compose file, or nil for all services.
+func logServices(projName string, names []string) ([]string, error) {
+	_, services, _, err := db.ProjectResources(projName)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("project %s not found", projName))
+	}
+	if err != nil {
+		return nil, err
+	}
+	var full []string
+	for _, name := range names {
+		svcName := projName + "_" + name
+		if !slices.ContainsFunc(services, func(svc db.Service) bool { return svc.Name == svcName }) {
+			return nil, connect.NewError(
+				connect.CodeNotFound, fmt.Errorf("service %s not found in project %s", name, projName),
+			)
+		}
+		full = append(full, svcName)
+	}
+	return full, nil
+}
+
+type deployServiceServer struct {
+	deployv1connect.UnimplementedDeployServiceHandler
+}
+
+var deployKinds = map[string]deployv1.EventKind{
+	controller.DeployInfo:   deployv1.EventKind_EVENT_KIND_INFO,
+	controller.DeployPull:   deployv1.EventKind_EVENT_KIND_PULL,
+	controller.DeployCreate: deployv1.EventKind_EVENT_KIND_CREATE,
+	controller.DeployStart:  deployv1.EventKind_EVENT_KIND_START,
+	controller.DeployHealth: deployv1.EventKind_EVENT_KIND_HEALTH,
+	controller.DeployReady:  deployv1.EventKind_EVENT_KIND_READY,
+	controller.DeployFailed: deployv1.EventKind_EVENT_KIND_FAILED,
+}
+
+func (s *deployServiceServer) Deploy(
+	ctx context.Context,
+	req *connect.Request[deployv1.DeployRequest],
+	stream *connect.ServerStream[deployv1.DeployResponse],
+) error {
+	projName := req.Msg.GetProject()
+	log.Printf("Got a request to deploy project %s", projName)
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultDeployTimeout)
+		defer cancel()
+	}
+	// deploy holds services locked, so it stops when client is gone
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var sendErr error
+	err := controller.Deploy(ctx, projName, func(event controller.DeployEvent) {
+		if sendErr != nil {
+			return
+		}
+		err := stream.Send(&deployv1.DeployResponse{
+			Service:   event.Service,
+			Container: event.Container,
+			Kind:      deployKinds[event.Kind],
+			Message:   event.Message,
+			Layer:     event.Layer,
+			Current:   event.Current,
+			Total:     event.Total,
+			Health:    event.Health,
+		})
+		if err != nil {
+			log.Println(err)
+			sendErr = err
+			cancel()
+		}
+	})
+	if sendErr != nil {
+		return sendErr
+	}
+	if err != nil {
+		log.Println(err)
+		switch {
+		case errors.Is(err, db.ErrNotFound):
+			return connect.NewError(connect.CodeNotFound, fmt.Errorf("project '%s' not found", projName))
+		case errors.Is(err, db.ErrProjectStopped):
+			return connect.NewError(connect.CodeFailedPrecondition, err)
+		case errors.Is(err, context.DeadlineExceeded):
+			return connect.NewError(connect.CodeDeadlineExceeded, err)
+		case errors.Is(err, controller.ErrDeployFailed):
+			return connect.NewError(connect.CodeAborted, err)
+		}
+		return err
+	}
+	log.Println("Project", projName, "deployed and healthy")
+	return nil
+}
+
 func Run() {
 	mux := http.NewServeMux()
 	path, handler := logsv1connect.NewLoggerServiceHandler(&loggerServiceServer{})
 	mux.Handle(path, handler)
+	path, handler = deployv1connect.NewDeployServiceHandler(&deployServiceServer{})
+	mux.Handle(path, handler)
 	// TODO: probably best to disable reflection on non-dev deployment
 	reflector := grpcreflect.NewStaticReflector(
 		logsv1connect.LoggerServiceName,
+		deployv1connect.DeployServiceName,
 	)
 	mux.Handle(grpcreflect.NewHandlerV1(reflector))
 	log.Println("oOoOo gRPC listening on", address, "oOoOo")
@@ -1,19 +1,30 @@
 package server
 
 import (
+	"archive/tar"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/compose-spec/compose-go/v2/types"
+	"github.com/docker/docker/pkg/jsonmessage"
 	"github.com/pgulb/plasma/container"
+	"github.com/pgulb/plasma/controller"
 	"github.com/pgulb/plasma/db"
 	"github.com/pgulb/plasma/version"
 )
 
 type RespMsg struct {
-	Msg string `json:"msg"`
+	Msg    string            `json:"msg"`
+	Issues []container.Issue `json:"issues,omitempty"` // compose validation warnings and errors
 }
 
 type CtrStatus struct {
@@ -22,10 +33,33 @@ type CtrStatus struct {
 }
 
 type PsResp struct {
-	Projects []db.Project `json:"projects"`
-	Services []db.Service `json:"services"`
-	Volumes  []db.Volume  `json:"volumes"`
-	Statuses []CtrStatus  `json:"statuses"`
+	Projects   []db.Project         `json:"projects"`
+	Services   []db.Service         `json:"services"`
+	Volumes    []db.Volume          `json:"volumes"`
+	Statuses   []CtrStatus          `json:"statuses"`
+	Reconciles []db.ReconcileStatus `json:"reconciles"`
+}
+
+type SvcResp struct {
+	Service   db.Service          `json:"service"`
+	Status    string              `json:"status"`
+	Reconcile *db.ReconcileStatus `json:"reconcile"`
+}
+
+type PlanResp struct {
+	Changes []db.Change       `json:"changes"`
+	Issues  []container.Issue `json:"issues"`
+}
+
+type RegistryResp struct {
+	Host     string `json:"host"`
+	Project  string `json:"project"` // empty for all projects
+	Username string `json:"username"`
+}
+
+type LoginReq struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
 }
 
 func Msg(msg string) []byte {
@@ -33,6 +67,34 @@ func Msg(msg string) []byte {
 	return b
 }
 
+// MsgIssues is Msg with issues found in uploaded compose file.
+func MsgIssues(msg string, issues []container.Issue) []byte {
+	b, _ := json.Marshal(RespMsg{Msg: msg, Issues: issues})
+	return b
+}
+
+// serviceStatus returns state of service's container, or for services
+// with more replicas how many of them are running, e.g. "running 2/3".
+func serviceStatus(ctx context.Context, svc *db.Service) (string, error) {
+	ctrs, err := container.ListService(ctx, svc.Name)
+	if err != nil {
+		return "", err
+	}
+	if len(ctrs) == 0 {
+		return "unknown", nil
+	}
+	if svc.Replicas <= 1 && len(ctrs) == 1 {
+		return ctrs[0].State, nil
+	}
+	running := 0
+	for _, ctr := range ctrs {
+		if ctr.State == "running" {
+			running++
+		}
+	}
+	return fmt.Sprintf("running %v/%v", running, svc.Replicas), nil
+}
+
 func Health(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
@@ -52,15 +114,40 @@ func Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	project, err := container.ParseCompose(projName, cmps)
+	decoded, err := container.DecodeCompose(cmps)
 	if err != nil {
-		log.Println(err)
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write(Msg(err.Error()))
 		return
 	}
+	project, issues, err := container.LoadCompose(projName, decoded, q.Get("strict") == "true")
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(MsgIssues(err.Error(), issues))
+		return
+	}
+
+	builds, err := useBuilds(project, nil)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(MsgIssues(err.Error(), issues))
+		return
+	}
+	digests, pinIssues := pinDigests(r.Context(), project, nil)
+	issues = append(issues, pinIssues...)
+	platformIssues, err := container.ValidatePlatforms(r.Context(), project, digests)
+	issues = append(issues, platformIssues...)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(MsgIssues(err.Error(), issues))
+		return
+	}
 
-	err = db.NewProjectToDB(project)
+	rev := newRevision(r, decoded, "create", builds, digests)
+	err = db.NewProjectToDB(project, rev)
 	if err != nil {
 		log.Println(err)
 		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
@@ -74,36 +161,371 @@ func Create(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.WriteHeader(http.StatusCreated)
-	w.Write(Msg(fmt.Sprintf("Project '%s' created", projName)))
+	w.Write(MsgIssues(fmt.Sprintf("Project '%s' created", projName), issues))
 }
 
-func Ps(w http.ResponseWriter, r *http.Request) {
-	projs, svcs, vols, err := db.Ps()
+// newRevision prepares revision of the project, with images built for it,
+// digests its images are pinned to and author given by CLI.
+func newRevision(
+	r *http.Request,
+	compose []byte,
+	source string,
+	builds map[string]string,
+	digests map[string]string,
+) *db.Revision {
+	author := r.URL.Query().Get("author")
+	if author == "" {
+		author = "unknown"
+	}
+	digestsBytes, err := json.Marshal(digests)
+	if err != nil {
+		log.Println(err)
+	}
+	buildsBytes, err := json.Marshal(builds)
+	if err != nil {
+		log.Println(err)
+	}
+	return &db.Revision{
+		Compose: string(compose),
+		Digests: string(digestsBytes),
+		Builds:  string(buildsBytes),
+		Author:  author + " (" + r.RemoteAddr + ")",
+		Source:  source,
+	}
+}
+
+// pinDigests resolves images of project's services to digests they are
+// pinned to, reusing pins given for them, e.g. by revision rolled back to.
+// Images which can't be resolved are not pinned and reported as warnings.
+func pinDigests(ctx context.Context, project *types.Project, pins map[string]string) (map[string]string, []container.Issue) {
+	var projID uint
+	proj, err := db.GetProject(project.Name)
+	if err == nil {
+		projID = proj.ID
+	}
+	digests := map[string]string{}
+	issues := []container.Issue{}
+	for _, name := range project.ServiceNames() {
+		svc := project.Services[name]
+		if svc.Image == "" || svc.Build != nil {
+			continue
+		}
+		if _, ok := digests[svc.Image]; ok {
+			continue
+		}
+		// revisions made before pinning have other digests, resolve them again
+		if pin, ok := pins[svc.Image]; ok && container.IsDigestOf(pin, svc.Image) {
+			digests[svc.Image] = pin
+			continue
+		}
+		digest, err := container.PinDigest(ctx, svc.Image, svc.PullPolicy, projID)
+		if err != nil {
+			log.Println(err)
+			issues = append(issues, container.Issue{
+				Severity: container.SeverityWarning,
+				Service:  name,
+				Key:      "image",
+				Message:  fmt.Sprintf("%s is not pinned to digest: %s", svc.Image, err),
+			})
+			continue
+		}
+		if digest != "" {
+			digests[svc.Image] = digest
+		}
+	}
+	return digests, issues
+}
+
+// currentPins returns digests services of the project are pinned to now,
+// by image, so applying compose file does not move unchanged images.
+func currentPins(projName string) (map[string]string, error) {
+	pins := map[string]string{}
+	_, svcs, _, err := db.ProjectResources(projName)
+	if errors.Is(err, db.ErrNotFound) {
+		return pins, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, svc := range svcs {
+		if svc.ImageDigest != nil {
+			pins[svc.Image] = *svc.ImageDigest
+		}
+	}
+	return pins, nil
+}
+
+// storedDigests is pinDigests for plan, it only uses pins services have
+// now, images which would be resolved on apply are reported as warnings.
+func storedDigests(project *types.Project, pins map[string]string) (map[string]string, []container.Issue) {
+	digests := map[string]string{}
+	issues := []container.Issue{}
+	for _, name := range project.ServiceNames() {
+		svc := project.Services[name]
+		if svc.Image == "" || svc.Build != nil || !db.Pinnable(svc.PullPolicy) {
+			continue
+		}
+		if pin, ok := pins[svc.Image]; ok && container.IsDigestOf(pin, svc.Image) {
+			digests[svc.Image] = pin
+			continue
+		}
+		issues = append(issues, container.Issue{
+			Severity: container.SeverityWarning,
+			Service:  name,
+			Key:      "image",
+			Message:  fmt.Sprintf("%s is not resolved to digest yet, it will be on apply", svc.Image),
+		})
+	}
+	return digests, issues
+}
+
+// useBuilds points services with build section at images plasma built
+// for them, the latest ones or those with ids given by service,
+// and returns ids of used images by service.
+func useBuilds(project *types.Project, imageIDs map[string]string) (map[string]string, error) {
+	used := map[string]string{}
+	var errs []error
+	for _, name := range project.ServiceNames() {
+		svc := project.Services[name]
+		if svc.Build == nil {
+			continue
+		}
+		var build *db.Build
+		var err error
+		if imageIDs == nil {
+			build, err = db.LatestBuild(project.Name, name)
+		} else {
+			build, err = db.GetBuild(project.Name, name, imageIDs[name])
+		}
+		if errors.Is(err, db.ErrNotFound) {
+			errs = append(errs, fmt.Errorf("no image was built for service %s, upload its build context first", name))
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		svc.Image = build.Image
+		project.Services[name] = svc
+		used[name] = build.ImageID
+	}
+	return used, errors.Join(errs...)
+}
+
+// applyCompose updates existing project to match compose file
+// and removes containers of services no longer in it. Services with build
+// section use images with given ids, or the latest built ones if nil.
+// Images are pinned to given digests, others are resolved, so pins
+// services have now should be given to keep them.
+// It returns issues found in compose file.
+func applyCompose(
+	r *http.Request,
+	projName string,
+	compose []byte,
+	source string,
+	strict bool,
+	imageIDs map[string]string,
+	pins map[string]string,
+) ([]container.Issue, error) {
+	project, issues, err := container.LoadCompose(projName, compose, strict)
+	if err != nil {
+		return issues, err
+	}
+	builds, err := useBuilds(project, imageIDs)
+	if err != nil {
+		return issues, err
+	}
+	digests, pinIssues := pinDigests(r.Context(), project, pins)
+	issues = append(issues, pinIssues...)
+	platformIssues, err := container.ValidatePlatforms(r.Context(), project, digests)
+	issues = append(issues, platformIssues...)
+	if err != nil {
+		return issues, err
+	}
+	removed, err := db.ApplyProjectToDB(project, newRevision(r, compose, source, builds, digests))
+	if err != nil {
+		return issues, err
+	}
+	for _, svc := range removed {
+		err := controller.RemoveService(&svc)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	return issues, nil
+}
+
+func Apply(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	cmps := q.Get("compose")
+	if cmps == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(Msg("compose param is required"))
+		return
+	}
+	projName := q.Get("project")
+	if projName == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(Msg("project param is required"))
+		return
+	}
+
+	decoded, err := container.DecodeCompose(cmps)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	// images are resolved again only by update-images
+	pins, err := currentPins(projName)
 	if err != nil {
 		log.Println(err)
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write(Msg(err.Error()))
 		return
 	}
-	statuses := []CtrStatus{}
-	for _, svc := range svcs {
-		// TODO: change to ContainerList, will be probably faster and less prone to
-		// listing containers that were just killed by controller
-		ctr, err := container.Get(svc.Name)
+	issues, err := applyCompose(r, projName, decoded, "apply", q.Get("strict") == "true", nil, pins)
+	if err != nil {
+		log.Println(err)
+		if errors.Is(err, db.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(Msg(fmt.Sprintf("Project '%s' not found, create it first", projName)))
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(MsgIssues(err.Error(), issues))
+		return
+	}
+
+	w.Write(MsgIssues(fmt.Sprintf(
+		"Project '%s' applied, controller will update changed services on its next run",
+		projName,
+	), issues))
+}
+
+// Plan reports what applying compose file would change in the project,
+// without saving it or touching docker. Images are planned with digests
+// services are pinned to now.
+func Plan(w http.ResponseWriter, r *http.Request) {
+	projName := r.PathValue("name")
+	cmps := r.URL.Query().Get("compose")
+	if cmps == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(Msg("compose param is required"))
+		return
+	}
+	// plan reports issues instead of rejecting compose file
+	project, issues, err := container.ParseCompose(projName, cmps, false)
+	if err != nil && !errors.Is(err, container.ErrInvalidCompose) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	// services not built yet are planned without image
+	_, err = useBuilds(project, nil)
+	if err != nil {
+		log.Println(err)
+	}
+	pins, err := currentPins(projName)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	digests, pinIssues := storedDigests(project, pins)
+	issues = append(issues, pinIssues...)
+	issues = append(issues, container.CheckPlatforms(project)...)
+	changes, err := db.PlanProject(project, digests)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	_, err = db.GetProject(projName)
+	if errors.Is(err, db.ErrNotFound) {
+		changes = append(changes, db.Change{
+			Kind:   "network",
+			Name:   container.NetworkName(projName),
+			Action: "create",
+		})
+	}
+	b, err := json.Marshal(PlanResp{Changes: changes, Issues: issues})
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	w.Write(Msg(string(b)))
+}
+
+// Build builds images of services from build contexts uploaded by CLI,
+// streaming build output as json messages. Request body is a tar with
+// context tar named <service>.tar for every service to build.
+func Build(w http.ResponseWriter, r *http.Request) {
+	projName := r.PathValue("name")
+	cmps := r.URL.Query().Get("compose")
+	if cmps == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(Msg("compose param is required"))
+		return
+	}
+	// issues are reported on create or apply
+	project, _, err := container.ParseCompose(projName, cmps, false)
+	if err != nil && !errors.Is(err, container.ErrInvalidCompose) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	buildErr := func(err error) {
+		log.Println(err)
+		enc.Encode(jsonmessage.JSONMessage{Error: &jsonmessage.JSONError{Message: err.Error()}})
+	}
+	bundle := tar.NewReader(r.Body)
+	for {
+		hdr, err := bundle.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
 		if err != nil {
-			log.Println(err)
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write(Msg(err.Error()))
+			buildErr(err)
 			return
 		}
-		if ctr == nil {
-			statuses = append(statuses, CtrStatus{Name: svc.Name, Status: "unknown"})
-		} else {
-			statuses = append(statuses, CtrStatus{Name: svc.Name, Status: ctr.State.Status})
+		svcName := strings.TrimSuffix(hdr.Name, ".tar")
+		enc.Encode(jsonmessage.JSONMessage{Stream: fmt.Sprintf("Building %s...\n", svcName)})
+		imageID, image, err := container.BuildImage(r.Context(), project, svcName, bundle, w)
+		if err != nil {
+			buildErr(fmt.Errorf("build of %s failed: %w", svcName, err))
+			return
+		}
+		err = db.AddBuild(&db.Build{
+			Project: projName,
+			Service: svcName,
+			ImageID: imageID,
+			Image:   image,
+		})
+		if err != nil {
+			buildErr(err)
+			return
 		}
+		enc.Encode(jsonmessage.JSONMessage{Stream: fmt.Sprintf("Built %s as %s\n", svcName, image)})
 	}
-	psResp := PsResp{Projects: projs, Services: svcs, Volumes: vols, Statuses: statuses}
-	b, err := json.Marshal(psResp)
+}
+
+// PushImage loads images from 'docker save' output sent as request body.
+func PushImage(w http.ResponseWriter, r *http.Request) {
+	loaded, err := container.LoadImage(r.Context(), r.Body)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	b, err := json.Marshal(loaded)
 	if err != nil {
 		log.Println(err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -113,14 +535,698 @@ func Ps(w http.ResponseWriter, r *http.Request) {
 	w.Write(Msg(string(b)))
 }
 
-func Version(w http.ResponseWriter, r *http.Request) {
-	w.Write(Msg(version.Version))
+// RegistryLogin checks credentials against registry and stores them,
+// for given project only or for all projects.
+func RegistryLogin(w http.ResponseWriter, r *http.Request) {
+	host := container.RegistryHost(r.PathValue("host"))
+	projName := r.URL.Query().Get("project")
+	var login LoginReq
+	err := json.NewDecoder(r.Body).Decode(&login)
+	if err != nil || login.Username == "" || login.Password == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(Msg("username and password are required"))
+		return
+	}
+	err = container.RegistryLogin(r.Context(), host, login.Username, login.Password)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	err = db.SaveRegistryCredential(host, projName, login.Username, login.Password)
+	if err != nil {
+		log.Println(err)
+		if errors.Is(err, db.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(Msg(fmt.Sprintf("Project '%s' not found", projName)))
+			return
+		}
+		if errors.Is(err, db.ErrNoSecretKey) {
+			w.WriteHeader(http.StatusConflict)
+			w.Write(Msg(err.Error()))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	if projName == "" {
+		w.Write(Msg(fmt.Sprintf("Logged in to %s for all projects", host)))
+		return
+	}
+	w.Write(Msg(fmt.Sprintf("Logged in to %s for project '%s'", host, projName)))
 }
 
-func Run() {
-	log.Println("oOoOo Starting plasma-server oOoOo")
+func RegistryLogout(w http.ResponseWriter, r *http.Request) {
+	host := container.RegistryHost(r.PathValue("host"))
+	projName := r.URL.Query().Get("project")
+	err := db.DeleteRegistryCredential(host, projName)
+	if err != nil {
+		log.Println(err)
+		if errors.Is(err, db.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(Msg(fmt.Sprintf("No login to %s found", host)))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	w.Write(Msg(fmt.Sprintf("Logged out of %s", host)))
+}
 
-	err := db.Init()
+func Registries(w http.ResponseWriter, r *http.Request) {
+	creds, err := db.RegistryCredentials()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	resp := []RegistryResp{}
+	for _, cred := range creds {
+		var projName string
+		if cred.ProjectId != 0 {
+			projName, err = db.ProjectName(cred.ProjectId)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write(Msg(err.Error()))
+				return
+			}
+		}
+		resp = append(resp, RegistryResp{Host: cred.Host, Project: projName, Username: cred.Username})
+	}
+	b, err := json.Marshal(resp)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	w.Write(Msg(string(b)))
+}
+
+func History(w http.ResponseWriter, r *http.Request) {
+	projName := r.PathValue("name")
+	revs, err := db.Revisions(projName)
+	if err != nil {
+		log.Println(err)
+		if errors.Is(err, db.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(Msg(fmt.Sprintf("Project '%s' not found", projName)))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	b, err := json.Marshal(revs)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	w.Write(Msg(string(b)))
+}
+
+// revisionParam reads revision number from query param
+// and loads it, writing error response if it fails.
+func revisionParam(w http.ResponseWriter, r *http.Request, param string) *db.Revision {
+	projName := r.PathValue("name")
+	number, err := strconv.ParseUint(r.URL.Query().Get(param), 10, 32)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(Msg(fmt.Sprintf("%s param must be a revision number", param)))
+		return nil
+	}
+	rev, err := db.GetRevision(projName, uint(number))
+	if err != nil {
+		log.Println(err)
+		if errors.Is(err, db.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(Msg(fmt.Sprintf("Revision %v of project '%s' not found", number, projName)))
+			return nil
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return nil
+	}
+	return rev
+}
+
+func Diff(w http.ResponseWriter, r *http.Request) {
+	from := revisionParam(w, r, "from")
+	if from == nil {
+		return
+	}
+	to := revisionParam(w, r, "to")
+	if to == nil {
+		return
+	}
+	fromSpec, err := from.ParseSpec()
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	toSpec, err := to.ParseSpec()
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	changes, err := db.DiffSpec(fromSpec, toSpec)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	b, err := json.Marshal(changes)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	w.Write(Msg(string(b)))
+}
+
+func Rollback(w http.ResponseWriter, r *http.Request) {
+	projName := r.PathValue("name")
+	rev := revisionParam(w, r, "to")
+	if rev == nil {
+		return
+	}
+	imageIDs, err := revisionBuilds(rev)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	digests, err := rev.ParseDigests()
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	issues, err := applyCompose(
+		r, projName, []byte(rev.Compose), fmt.Sprintf("rollback to %v", rev.Number), false, imageIDs, digests,
+	)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(MsgIssues(err.Error(), issues))
+		return
+	}
+	w.Write(MsgIssues(fmt.Sprintf(
+		"Project '%s' rolled back to revision %v, controller will update changed services on its next run",
+		projName,
+		rev.Number,
+	), issues))
+}
+
+// revisionBuilds returns ids of images built for services used by revision.
+// Revisions made before builds were recorded give nil, so latest built
+// images are used.
+func revisionBuilds(rev *db.Revision) (map[string]string, error) {
+	if rev.Builds == "" {
+		return nil, nil
+	}
+	var imageIDs map[string]string
+	err := json.Unmarshal([]byte(rev.Builds), &imageIDs)
+	if err != nil {
+		return nil, err
+	}
+	return imageIDs, nil
+}
+
+// UpdateImages pins services to digests their images have in registry now,
+// as a new revision of the project's current compose file. With service
+// params only images of given services are resolved again, other services
+// keep their pins.
+func UpdateImages(w http.ResponseWriter, r *http.Request) {
+	projName := r.PathValue("name")
+	svcNames := r.URL.Query()["service"]
+	revs, err := db.Revisions(projName)
+	if err != nil {
+		log.Println(err)
+		if errors.Is(err, db.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(Msg(fmt.Sprintf("Project '%s' not found", projName)))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	if len(revs) == 0 {
+		w.WriteHeader(http.StatusConflict)
+		w.Write(Msg(fmt.Sprintf("Project '%s' has no revisions, apply it first", projName)))
+		return
+	}
+	_, svcs, _, err := db.ProjectResources(projName)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	updated := map[string]bool{} // images to resolve again
+	for _, svc := range svcs {
+		if len(svcNames) == 0 || slices.Contains(svcNames, strings.TrimPrefix(svc.Name, projName+"_")) {
+			updated[svc.Image] = true
+		}
+	}
+	for _, name := range svcNames {
+		if !slices.ContainsFunc(svcs, func(svc db.Service) bool { return svc.Name == projName+"_"+name }) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(Msg(fmt.Sprintf("Service '%s' not found in project '%s'", name, projName)))
+			return
+		}
+	}
+	// current pins, including ones moved by auto-update
+	pins := map[string]string{}
+	before := map[string]string{}
+	for _, svc := range svcs {
+		if svc.ImageDigest == nil {
+			continue
+		}
+		before[svc.Name] = *svc.ImageDigest
+		if !updated[svc.Image] {
+			pins[svc.Image] = *svc.ImageDigest
+		}
+	}
+	last := revs[len(revs)-1]
+	imageIDs, err := revisionBuilds(&last)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	issues, err := applyCompose(r, projName, []byte(last.Compose), "update-images", false, imageIDs, pins)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(MsgIssues(err.Error(), issues))
+		return
+	}
+	_, svcs, _, err = db.ProjectResources(projName)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	changed := []string{}
+	for _, svc := range svcs {
+		if svc.ImageDigest != nil && *svc.ImageDigest != before[svc.Name] {
+			changed = append(changed, fmt.Sprintf("%s: %s", svc.Name, *svc.ImageDigest))
+		}
+	}
+	if len(changed) == 0 {
+		w.Write(MsgIssues(fmt.Sprintf("Images of project '%s' are up to date", projName), issues))
+		return
+	}
+	w.Write(MsgIssues(fmt.Sprintf(
+		"Project '%s' pinned to new digests, controller will update changed services on its next run:\n  %s",
+		projName,
+		strings.Join(changed, "\n  "),
+	), issues))
+}
+
+func Ps(w http.ResponseWriter, r *http.Request) {
+	projs, svcs, vols, reconciles, err := db.Ps()
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	statuses := []CtrStatus{}
+	for _, svc := range svcs {
+		status, err := serviceStatus(r.Context(), &svc)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(Msg(err.Error()))
+			return
+		}
+		statuses = append(statuses, CtrStatus{Name: svc.Name, Status: status})
+	}
+	psResp := PsResp{
+		Projects:   projs,
+		Services:   svcs,
+		Volumes:    vols,
+		Statuses:   statuses,
+		Reconciles: reconciles,
+	}
+	b, err := json.Marshal(psResp)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	w.Write(Msg(string(b)))
+}
+
+func Service(w http.ResponseWriter, r *http.Request) {
+	projName := r.PathValue("name")
+	svcName := r.PathValue("svc")
+	svc, reconcile, err := db.GetService(projName, svcName)
+	if err != nil {
+		log.Println(err)
+		if errors.Is(err, db.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(Msg(fmt.Sprintf("Service '%s' not found in project '%s'", svcName, projName)))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	status, err := serviceStatus(r.Context(), svc)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	b, err := json.Marshal(SvcResp{Service: *svc, Status: status, Reconcile: reconcile})
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	w.Write(Msg(string(b)))
+}
+
+func setPaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	projName := r.PathValue("name")
+	svcNames := r.URL.Query()["service"]
+	err := db.SetPaused(projName, svcNames, paused)
+	if err != nil {
+		log.Println(err)
+		if errors.Is(err, db.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(Msg(err.Error()))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	verb := "unpaused"
+	if paused {
+		verb = "paused"
+	}
+	if len(svcNames) == 0 {
+		w.Write(Msg(fmt.Sprintf("Project '%s' %s", projName, verb)))
+		return
+	}
+	w.Write(Msg(fmt.Sprintf(
+		"Services %s of project '%s' %s",
+		strings.Join(svcNames, ", "),
+		projName,
+		verb,
+	)))
+}
+
+func Pause(w http.ResponseWriter, r *http.Request) {
+	setPaused(w, r, true)
+}
+
+func Unpause(w http.ResponseWriter, r *http.Request) {
+	setPaused(w, r, false)
+}
+
+func setDesiredState(w http.ResponseWriter, r *http.Request, state string) {
+	projName := r.PathValue("name")
+	svcNames := r.URL.Query()["service"]
+	err := db.SetDesiredState(projName, svcNames, state)
+	if err != nil {
+		log.Println(err)
+		if errors.Is(err, db.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(Msg(err.Error()))
+			return
+		}
+		if errors.Is(err, db.ErrProjectStopped) {
+			w.WriteHeader(http.StatusConflict)
+			w.Write(Msg(err.Error()))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	if len(svcNames) == 0 {
+		w.Write(Msg(fmt.Sprintf("Project '%s' desired state set to %s", projName, state)))
+		return
+	}
+	w.Write(Msg(fmt.Sprintf(
+		"Services %s of project '%s' desired state set to %s",
+		strings.Join(svcNames, ", "),
+		projName,
+		state,
+	)))
+}
+
+func Stop(w http.ResponseWriter, r *http.Request) {
+	setDesiredState(w, r, db.StateStopped)
+}
+
+func Start(w http.ResponseWriter, r *http.Request) {
+	setDesiredState(w, r, db.StateRunning)
+}
+
+func Scale(w http.ResponseWriter, r *http.Request) {
+	projName := r.PathValue("name")
+	svcName := r.PathValue("svc")
+	replicas, err := strconv.ParseUint(r.URL.Query().Get("replicas"), 10, 32)
+	if err != nil || replicas == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(Msg("replicas param must be a positive number, use stop to stop a service"))
+		return
+	}
+	svc, _, err := db.GetService(projName, svcName)
+	if err != nil {
+		log.Println(err)
+		if errors.Is(err, db.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(Msg(fmt.Sprintf("Service '%s' not found in project '%s'", svcName, projName)))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	publishes, err := svc.PublishesPorts()
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	if publishes && replicas > 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(Msg(fmt.Sprintf(
+			"Service '%s' publishes host ports, it can't run more than one replica",
+			svcName,
+		)))
+		return
+	}
+	err = db.SetReplicas(projName, svcName, uint(replicas))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	w.Write(Msg(fmt.Sprintf("Service '%s' scaled to %v replicas", svc.Name, replicas)))
+}
+
+func Restart(w http.ResponseWriter, r *http.Request) {
+	projName := r.PathValue("name")
+	svcName := r.PathValue("svc")
+	q := r.URL.Query()
+	pull := q.Get("pull") == "true"
+	timeout := 60 * time.Second
+	if t := q.Get("timeout"); t != "" {
+		var err error
+		timeout, err = time.ParseDuration(t)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(Msg("timeout param is not valid duration"))
+			return
+		}
+	}
+	proj, err := db.GetProject(projName)
+	if err != nil {
+		log.Println(err)
+		if errors.Is(err, db.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(Msg(fmt.Sprintf("Project '%s' not found", projName)))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	if proj.DesiredState == db.StateStopped {
+		w.WriteHeader(http.StatusConflict)
+		w.Write(Msg(fmt.Sprintf("Project '%s' is stopped, start it first", projName)))
+		return
+	}
+	svc, _, err := db.GetService(projName, svcName)
+	if err != nil {
+		log.Println(err)
+		if errors.Is(err, db.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(Msg(fmt.Sprintf("Service '%s' not found in project '%s'", svcName, projName)))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	err = controller.RestartService(r.Context(), svc, pull, timeout)
+	if err != nil {
+		log.Println(err)
+		if errors.Is(err, controller.ErrServiceStopped) {
+			w.WriteHeader(http.StatusConflict)
+			w.Write(Msg(err.Error()))
+			return
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			w.WriteHeader(http.StatusGatewayTimeout)
+			w.Write(Msg(err.Error()))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	w.Write(Msg(fmt.Sprintf("Service '%s' restarted and healthy", svc.Name)))
+}
+
+func Events(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := 50
+	if l := q.Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(Msg("limit param must be a positive number"))
+			return
+		}
+	}
+	events, err := db.Events(q.Get("project"), limit)
+	if err != nil {
+		log.Println(err)
+		if errors.Is(err, db.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(Msg(fmt.Sprintf("Project '%s' not found", q.Get("project"))))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	b, err := json.Marshal(events)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	w.Write(Msg(string(b)))
+}
+
+func Images(w http.ResponseWriter, r *http.Request) {
+	imgs, err := controller.ManagedImages(r.Context())
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	b, err := json.Marshal(imgs)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	w.Write(Msg(string(b)))
+}
+
+func Orphans(w http.ResponseWriter, r *http.Request) {
+	orphans, err := controller.FindOrphans(r.Context())
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	b, err := json.Marshal(orphans)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	w.Write(Msg(string(b)))
+}
+
+func Prune(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	opts := controller.PruneOpts{
+		Containers: q.Get("containers") == "true",
+		Volumes:    q.Get("volumes") == "true",
+		Networks:   q.Get("networks") == "true",
+		Images:     q.Get("images") == "true",
+		DryRun:     q.Get("dry_run") == "true",
+	}
+	removed, err := controller.Prune(r.Context(), opts)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	b, err := json.Marshal(removed)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(Msg(err.Error()))
+		return
+	}
+	w.Write(Msg(string(b)))
+}
+
+func Version(w http.ResponseWriter, r *http.Request) {
+	w.Write(Msg(version.Version))
+}
+
+func Run() {
+	log.Println("oOoOo Starting plasma-server oOoOo")
+
+	err := db.Init()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// images are listed with statuses image GC gives them
+	_, err = controller.LoadImageGC()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -129,7 +1235,35 @@ func Run() {
 
 	mux.Handle("GET /healthz", LoggerMiddleware(http.HandlerFunc(Health)))
 	mux.Handle("POST /create", LoggerMiddleware(http.HandlerFunc(Create)))
+	mux.Handle("POST /apply", LoggerMiddleware(http.HandlerFunc(Apply)))
+	mux.Handle("POST /projects/{name}/build", LoggerMiddleware(http.HandlerFunc(Build)))
+	mux.Handle("POST /projects/{name}/plan", LoggerMiddleware(http.HandlerFunc(Plan)))
+	mux.Handle("GET /projects/{name}/revisions", LoggerMiddleware(http.HandlerFunc(History)))
+	mux.Handle("GET /projects/{name}/diff", LoggerMiddleware(http.HandlerFunc(Diff)))
+	mux.Handle("POST /projects/{name}/rollback", LoggerMiddleware(http.HandlerFunc(Rollback)))
+	mux.Handle("POST /projects/{name}/update-images", LoggerMiddleware(http.HandlerFunc(UpdateImages)))
 	mux.Handle("GET /ps", LoggerMiddleware(http.HandlerFunc(Ps)))
+	mux.Handle("GET /projects/{name}/services/{svc}", LoggerMiddleware(http.HandlerFunc(Service)))
+	mux.Handle("POST /projects/{name}/pause", LoggerMiddleware(http.HandlerFunc(Pause)))
+	mux.Handle("POST /projects/{name}/unpause", LoggerMiddleware(http.HandlerFunc(Unpause)))
+	mux.Handle("POST /projects/{name}/stop", LoggerMiddleware(http.HandlerFunc(Stop)))
+	mux.Handle("POST /projects/{name}/start", LoggerMiddleware(http.HandlerFunc(Start)))
+	mux.Handle(
+		"POST /projects/{name}/services/{svc}/scale",
+		LoggerMiddleware(http.HandlerFunc(Scale)),
+	)
+	mux.Handle(
+		"POST /projects/{name}/services/{svc}/restart",
+		LoggerMiddleware(http.HandlerFunc(Restart)),
+	)
+	mux.Handle("GET /events", LoggerMiddleware(http.HandlerFunc(Events)))
+	mux.Handle("GET /images", LoggerMiddleware(http.HandlerFunc(Images)))
+	mux.Handle("POST /images", LoggerMiddleware(http.HandlerFunc(PushImage)))
+	mux.Handle("GET /registries", LoggerMiddleware(http.HandlerFunc(Registries)))
+	mux.Handle("POST /registries/{host}/login", LoggerMiddleware(http.HandlerFunc(RegistryLogin)))
+	mux.Handle("POST /registries/{host}/logout", LoggerMiddleware(http.HandlerFunc(RegistryLogout)))
+	mux.Handle("GET /orphans", LoggerMiddleware(http.HandlerFunc(Orphans)))
+	mux.Handle("POST /prune", LoggerMiddleware(http.HandlerFunc(Prune)))
 	mux.Handle("GET /version", LoggerMiddleware(http.HandlerFunc(Version)))
 
 	log.Fatal(http.ListenAndServe(":8080", mux))